@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"beryju.org/korb/v2/pkg/kube"
+	"beryju.org/korb/v2/pkg/migrator"
+	"beryju.org/korb/v2/pkg/plan"
+)
+
+var (
+	applyFile   string
+	applyDryRun bool
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply -f plan.yaml",
+	Short: "Run all migrations from a plan file",
+	Long: `Run all migrations from a plan file (YAML or JSON).
+
+All migrations are validated before the first one is started. Migrations are run in order,
+and korb stops at the first failed migration.
+
+Example plan:
+
+  migrations:
+    - source:
+        namespace: prod
+        name: redis-data-redis-master-0
+      destination:
+        storageClass: ontap-ssd
+        size: 10Gi
+      strategy: copy-twice-name
+      mover:
+        copyTimeout: 30m`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		l := log.WithField("component", "apply")
+		p, err := plan.Load(applyFile)
+		if err != nil {
+			l.WithError(err).Panic("Failed to load plan")
+		}
+		_, ns, err := kube.NewConfig(kubeOptions())
+		if err != nil {
+			l.WithError(err).Panic("Failed to load kubeconfig")
+		}
+		p.SetDefaultNamespace(ns)
+		if err := p.Validate(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		migrators := make([]*migrator.Migrator, len(p.Migrations))
+		failed := false
+		for idx, mi := range p.Migrations {
+			m := mi.Migrator(cmd.Context(), kubeOptions())
+			if err := m.Prepare(); err != nil {
+				l.WithError(err).WithField("migration", idx).WithField("pvc", mi.Source.Name).Error("Validation failed")
+				failed = true
+			}
+			migrators[idx] = m
+		}
+		if failed {
+			os.Exit(1)
+		}
+		l.WithField("migrations", len(migrators)).Info("All migrations validated")
+		if applyDryRun {
+			return
+		}
+
+		for idx, m := range migrators {
+			ml := l.WithField("migration", idx).WithField("pvc", p.Migrations[idx].Source.Name)
+			ml.Info("Starting migration")
+			if err := m.Run(); err != nil {
+				ml.WithError(err).Error("Failed to migrate, stopping")
+				os.Exit(1)
+			}
+			fmt.Println("=====================")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().StringVarP(&applyFile, "filename", "f", "", "Plan file to apply, use - to read from stdin.")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Only validate the plan, don't run any migration.")
+	_ = applyCmd.MarkFlagRequired("filename")
+}
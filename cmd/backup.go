@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"beryju.org/korb/v2/pkg/backup"
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/kube"
+	"beryju.org/korb/v2/pkg/migrator"
+)
+
+// backupOptions are the flags of korb backup, which are separate from the flags of a migration
+type backupOptions struct {
+	dir              string
+	namespace        string
+	selector         string
+	force            bool
+	keepLast         int
+	keepDaily        int
+	keepWeekly       int
+	tolerateAllNodes bool
+	splitSize        string
+	encryption       encryptionOptions
+}
+
+var backupOpts backupOptions
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [pvc [pvc]]",
+	Short: "Export PVCs into timestamped archives and prune old archives",
+	Long: `Export PVCs into timestamped archives in a backup directory, for example a volume mounted into a CronJob.
+
+Archives are stored as <dir>/<namespace>/<pvc>/<time>.tar. After all PVCs are exported, archives which
+aren't kept by the retention policy are removed, and <dir>/index.json is updated with all available backups.
+Use 'korb restore' to import a backup into a PVC.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		l := log.WithField("component", "backup")
+		client, ns, err := kube.NewClient(kubeOptions())
+		if err != nil {
+			l.WithError(err).Panic("Failed to create client")
+		}
+		if backupOpts.namespace != "" {
+			ns = backupOpts.namespace
+		}
+		pvcs := args
+		if backupOpts.selector != "" {
+			list, err := client.CoreV1().PersistentVolumeClaims(ns).List(cmd.Context(), metav1.ListOptions{
+				LabelSelector: backupOpts.selector,
+			})
+			if err != nil {
+				l.WithError(err).Panic("Failed to list PVCs")
+			}
+			for _, pvc := range list.Items {
+				pvcs = append(pvcs, pvc.Name)
+			}
+		}
+		if len(pvcs) == 0 {
+			l.Panic("No PVCs to back up, pass PVC names or a selector")
+		}
+
+		store := backup.Store{Dir: backupOpts.dir}
+		failed := false
+		for _, pvc := range pvcs {
+			pl := l.WithField("pvc", pvc)
+			path, err := store.NewPath(ns, pvc, time.Now())
+			if err != nil {
+				pl.WithError(err).Error("Failed to back up PVC")
+				failed = true
+				continue
+			}
+			m := migrator.New(cmd.Context(), kubeOptions(), "export", backupOpts.tolerateAllNodes)
+			m.SourceNamespace = ns
+			m.DestNamespace = ns
+			m.SourcePVCName = pvc
+			m.ArchivePath = path
+			m.Force = backupOpts.force
+			m.Encryption = backupOpts.encryption.key()
+			m.SplitSize = archiveSplitSize(backupOpts.splitSize)
+			err = m.Run()
+			if err != nil {
+				pl.WithError(err).Error("Failed to back up PVC")
+				failed = true
+			} else {
+				pl.WithField("path", path).Info("Backed up PVC")
+			}
+			if cmd.Context().Err() != nil {
+				break
+			}
+		}
+
+		pruned, err := store.Prune(backup.Retention{
+			KeepLast:   backupOpts.keepLast,
+			KeepDaily:  backupOpts.keepDaily,
+			KeepWeekly: backupOpts.keepWeekly,
+		})
+		for _, b := range pruned {
+			l.WithField("path", b.Path).Info("Removed expired backup")
+		}
+		if err != nil {
+			l.WithError(err).Error("Failed to remove expired backups")
+			failed = true
+		}
+		err = store.WriteIndex()
+		if err != nil {
+			l.WithError(err).Error("Failed to write index")
+			failed = true
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.Flags().StringVar(&backupOpts.dir, "dir", "", "Directory to store backups in.")
+	backupCmd.Flags().StringVarP(&backupOpts.namespace, "namespace", "n", "", "Namespace of the PVCs. If empty, the namespace from your kubeconfig file will be used.")
+	backupCmd.Flags().StringVarP(&backupOpts.selector, "selector", "l", "", "Back up all PVCs matching this label selector, in addition to the PVCs passed as arguments.")
+	backupCmd.Flags().BoolVar(&backupOpts.force, "force", false, "Back up PVCs even if they are used by pods. The archive might not be consistent.")
+	backupCmd.Flags().BoolVar(&backupOpts.tolerateAllNodes, "tolerate-any-node", false, "Allow job to tolerating any node node taints.")
+	backupCmd.Flags().IntVar(&backupOpts.keepLast, "keep-last", 7, "Keep the newest n backups of each PVC.")
+	backupCmd.Flags().IntVar(&backupOpts.keepDaily, "keep-daily", 0, "Keep the newest backup of each of the last n days.")
+	backupCmd.Flags().IntVar(&backupOpts.keepWeekly, "keep-weekly", 0, "Keep the newest backup of each of the last n weeks.")
+	backupCmd.Flags().StringVar(&backupOpts.splitSize, "split-size", "", "Split archives into chunks of this size (Mi, Gi, ...). If empty, archives aren't split.")
+	addEncryptionFlags(backupCmd, &backupOpts.encryption)
+	backupCmd.Flags().StringVar(&config.ContainerImage, "container-image", config.ContainerImage, "Image to use for moving jobs")
+	_ = backupCmd.MarkFlagRequired("dir")
+}
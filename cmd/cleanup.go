@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"beryju.org/korb/v2/pkg/cleanup"
+	"beryju.org/korb/v2/pkg/kube"
+)
+
+var (
+	cleanupNamespace     string
+	cleanupAllNamespaces bool
+	cleanupMinAge        time.Duration
+	cleanupYes           bool
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Find and remove objects left behind by interrupted migrations",
+	Long: `Find and remove mover jobs, mover pods and temporary PVCs left behind by interrupted migrations.
+
+Temporary PVCs which might be the only remaining copy of some data, PVCs which are still
+mounted by a pod, and PVCs which only match the names of older korb versions are never removed.
+Retained source volumes are removed with 'korb prune-retained' instead.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		l := log.WithField("component", "cleanup")
+		client, ns, err := kube.NewClient(kubeOptions())
+		if err != nil {
+			l.WithError(err).Panic("Failed to create client")
+		}
+		f := cleanup.NewFinder(cmd.Context(), client)
+		f.MinAge = cleanupMinAge
+		f.Namespace = ns
+		if cleanupNamespace != "" {
+			f.Namespace = cleanupNamespace
+		}
+		if cleanupAllNamespaces {
+			f.Namespace = ""
+		}
+		leftovers, err := f.Find()
+		if err != nil {
+			l.WithError(err).Panic("Failed to find leftover objects")
+		}
+		if len(leftovers) == 0 {
+			l.Info("Nothing to clean up")
+			return
+		}
+
+		safe := make([]cleanup.Leftover, 0)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAMESPACE\tNAME\tMIGRATION\tAGE\tSTATE\tDELETE")
+		for _, lo := range leftovers {
+			action := "yes"
+			if lo.Safe {
+				safe = append(safe, lo)
+			} else {
+				action = "no, " + lo.Reason
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", lo.Kind, lo.Namespace, lo.Name, lo.MigrationID, lo.Age(), lo.State, action)
+		}
+		w.Flush()
+
+		if len(safe) == 0 {
+			l.Info("Nothing can be removed safely")
+			return
+		}
+		if !cleanupYes && !confirm(fmt.Sprintf("Delete %d objects?", len(safe))) {
+			return
+		}
+		for _, lo := range safe {
+			ll := l.WithField("kind", lo.Kind).WithField("namespace", lo.Namespace).WithField("name", lo.Name)
+			if err := lo.Delete(cmd.Context(), client); err != nil {
+				ll.WithError(err).Warning("Failed to delete")
+				continue
+			}
+			ll.Info("Deleted")
+		}
+	},
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+	cleanupCmd.Flags().StringVarP(&cleanupNamespace, "namespace", "n", "", "Namespace to clean up. If empty, the namespace from your kubeconfig file will be used.")
+	cleanupCmd.Flags().BoolVarP(&cleanupAllNamespaces, "all-namespaces", "A", false, "Clean up in all namespaces.")
+	cleanupCmd.Flags().DurationVar(&cleanupMinAge, "min-age", time.Hour, "Objects younger than this are considered part of a running migration and are not removed.")
+	cleanupCmd.Flags().BoolVarP(&cleanupYes, "yes", "y", false, "Don't ask for confirmation.")
+}
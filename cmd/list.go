@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"beryju.org/korb/v2/pkg/migrator"
+)
+
+var (
+	listNamespace string
+	listOutput    string
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List PVCs, their storage class and the workloads using them",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		m := migrator.New(cmd.Context(), kubeOptions(), "", false)
+		if listNamespace != "" {
+			m.SourceNamespace = listNamespace
+		}
+		infos, err := m.List()
+		if err != nil {
+			log.WithError(err).Panic("Failed to list PVCs")
+		}
+		switch listOutput {
+		case "json":
+			out, _ := json.MarshalIndent(infos, "", "  ")
+			fmt.Println(string(out))
+		case "yaml":
+			out, _ := yaml.Marshal(infos)
+			fmt.Print(string(out))
+		case "table":
+			printPVCTable(infos)
+		default:
+			log.WithField("output", listOutput).Panic("Unsupported output format")
+		}
+	},
+}
+
+func printPVCTable(infos []migrator.PVCInfo) {
+	orNone := func(s []string) string {
+		if len(s) == 0 {
+			return "<none>"
+		}
+		return strings.Join(s, ",")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTORAGECLASS\tSIZE\tACCESS MODES\tVOLUME\tPODS\tCONTROLLERS\tMIGRATING")
+	for _, info := range infos {
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%t\n",
+			info.Name, info.StorageClass, info.Size, orNone(info.AccessModes),
+			info.Volume, orNone(info.Pods), orNone(info.Controllers), info.MigrationInProgress,
+		)
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVarP(&listNamespace, "namespace", "n", "", "Namespace to list PVCs in. If empty, the namespace from your kubeconfig file will be used.")
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "Output format, one of table, json or yaml.")
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"beryju.org/korb/v2/pkg/operator"
+)
+
+var (
+	operatorNamespace      string
+	operatorInterval       time.Duration
+	operatorMaxConcurrent  int
+	operatorInstallCRD     bool
+	operatorLeaderElect    bool
+	operatorLeaseNamespace string
+)
+
+var operatorCmd = &cobra.Command{
+	Use:   "operator",
+	Short: "Run migrations requested by PVCMigration objects",
+	Long: `Watch PVCMigration objects and run the migrations they describe, reporting their progress in the status.
+
+The spec of a PVCMigration has the same fields as a migration in a plan file (see 'korb apply --help'),
+and an optional schedule:
+
+  apiVersion: korb.beryju.org/v1alpha1
+  kind: PVCMigration
+  metadata:
+    name: redis-ssd
+    namespace: prod
+  spec:
+    strategy: copy-twice-name
+    source:
+      name: redis-data-redis-master-0
+    destination:
+      storageClass: ontap-ssd
+    schedule:
+      windowStart: "22:00"
+      windowEnd: "04:00"
+      timeZone: Europe/Berlin
+
+Migrations which were running when the operator stopped are recovered on the next start.
+When multiple instances run, only the one holding the korb-operator Lease runs migrations.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := operator.NewController(cmd.Context(), kubeOptions())
+		if err != nil {
+			log.WithError(err).Panic("Failed to create controller")
+		}
+		if operatorInstallCRD {
+			err = operator.InstallCRD(cmd.Context(), c.Dynamic())
+			if err != nil {
+				log.WithError(err).Panic("Failed to install CustomResourceDefinition")
+			}
+			log.Info("Installed CustomResourceDefinition")
+		}
+		c.Namespace = operatorNamespace
+		c.Interval = operatorInterval
+		c.MaxConcurrent = operatorMaxConcurrent
+		c.LeaderElection = operatorLeaderElect
+		if operatorLeaseNamespace != "" {
+			c.LeaseNamespace = operatorLeaseNamespace
+		}
+		err = c.Run()
+		if err != nil {
+			log.WithError(err).Panic("Operator stopped")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(operatorCmd)
+	operatorCmd.Flags().StringVarP(&operatorNamespace, "namespace", "n", "", "Only watch PVCMigrations in this namespace. If empty, all namespaces are watched.")
+	operatorCmd.Flags().DurationVar(&operatorInterval, "interval", 30*time.Second, "Interval in which all PVCMigrations are reconciled.")
+	operatorCmd.Flags().IntVar(&operatorMaxConcurrent, "max-concurrent", 1, "Maximum number of migrations running at the same time.")
+	operatorCmd.Flags().BoolVar(&operatorLeaderElect, "leader-elect", true, "Use a Lease to make sure only one instance of the operator runs migrations.")
+	operatorCmd.Flags().StringVar(&operatorLeaseNamespace, "lease-namespace", "", "Namespace of the leader election Lease. If empty, the namespace of the current context (or of the service account) is used.")
+	operatorCmd.Flags().BoolVar(&operatorInstallCRD, "install-crd", false, "Create or update the PVCMigration CustomResourceDefinition before starting.")
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/kube"
+)
+
+var (
+	pruneGracePeriod time.Duration
+	pruneDryRun      bool
+)
+
+var pruneRetainedCmd = &cobra.Command{
+	Use:   "prune-retained",
+	Short: "Remove source PersistentVolumes retained by previous migrations",
+	Long: `Remove source PersistentVolumes which were retained by previous migrations, once they are older than the grace period.
+
+The reclaim policy of each volume is set back to its original value (usually Delete), so the storage provisioner removes the data.
+Volumes which are bound to a claim again (for example after a rollback) are never removed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		l := log.WithField("component", "prune-retained")
+		client, _, err := kube.NewClient(kubeOptions())
+		if err != nil {
+			l.WithError(err).Panic("Failed to create client")
+		}
+		pvs, err := client.CoreV1().PersistentVolumes().List(cmd.Context(), metav1.ListOptions{
+			LabelSelector: config.LabelMigrationID,
+		})
+		if err != nil {
+			l.WithError(err).Panic("Failed to list PersistentVolumes")
+		}
+		for _, pv := range pvs.Items {
+			pl := l.WithField("pv", pv.Name).
+				WithField("migration-id", pv.Labels[config.LabelMigrationID]).
+				WithField("source-pvc", pv.Annotations[config.AnnotationSourcePVC])
+			if pv.Status.Phase == v1.VolumeBound {
+				pl.Info("Volume is bound, skipping")
+				continue
+			}
+			retainedAt, err := time.Parse(time.RFC3339, pv.Annotations[config.AnnotationRetainedAt])
+			if err != nil {
+				pl.WithError(err).Warning("Failed to parse retention timestamp, skipping")
+				continue
+			}
+			if time.Since(retainedAt) < pruneGracePeriod {
+				pl.WithField("retained-at", retainedAt).Info("Volume is still within grace period, skipping")
+				continue
+			}
+			if pruneDryRun {
+				pl.Info("Would remove volume")
+				continue
+			}
+			policy := v1.PersistentVolumeReclaimPolicy(pv.Annotations[config.AnnotationOriginalReclaimPolicy])
+			if policy == "" || policy == v1.PersistentVolumeReclaimRetain {
+				// The volume was retained before korb touched it, so only remove the object
+				err = client.CoreV1().PersistentVolumes().Delete(cmd.Context(), pv.Name, metav1.DeleteOptions{})
+			} else {
+				pv.Spec.PersistentVolumeReclaimPolicy = policy
+				_, err = client.CoreV1().PersistentVolumes().Update(cmd.Context(), &pv, metav1.UpdateOptions{})
+			}
+			if err != nil {
+				pl.WithError(err).Warning("Failed to remove volume")
+				continue
+			}
+			pl.Info("Removed retained volume")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneRetainedCmd)
+	pruneRetainedCmd.Flags().DurationVar(&pruneGracePeriod, "grace-period", 7*24*time.Hour, "Only remove volumes which have been retained for longer than this.")
+	pruneRetainedCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only show which volumes would be removed.")
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"beryju.org/korb/v2/pkg/backup"
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/migrator"
+	"beryju.org/korb/v2/pkg/mover"
+	"beryju.org/korb/v2/pkg/strategies"
+)
+
+// restoreOptions are the flags of korb restore, which are separate from the flags of a migration
+type restoreOptions struct {
+	dir                string
+	namespace          string
+	at                 string
+	list               bool
+	force              bool
+	tolerateAllNodes   bool
+	pvcNewStorageClass string
+	pvcNewSize         string
+	pvcNewAccessModes  []string
+	importMode         string
+	ownership          mover.Ownership
+	encryption         encryptionOptions
+}
+
+var restoreOpts restoreOptions
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore pvc",
+	Short: "Import a backup created by korb backup into a PVC",
+	Long: `Import a backup created by 'korb backup' into a PVC.
+
+If the PVC doesn't exist, it is created with the storage class, size and access modes of the PVC the
+backup was taken from, unless they are overwritten with the --new-pvc-* flags.
+
+By default the newest backup is used. With --at, the newest backup taken at or before the given time is used.
+The time can be given as RFC3339 (2006-01-02T15:04:05Z) or as a date (2006-01-02), which selects the newest backup of that day.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		l := log.WithField("component", "restore").WithField("pvc", args[0])
+		m := migrator.New(cmd.Context(), kubeOptions(), "import", restoreOpts.tolerateAllNodes)
+		if restoreOpts.namespace != "" {
+			m.SourceNamespace = restoreOpts.namespace
+			m.DestNamespace = restoreOpts.namespace
+		}
+		store := backup.Store{Dir: restoreOpts.dir}
+		if restoreOpts.list {
+			printBackups(store, m.SourceNamespace, args[0])
+			return
+		}
+
+		at := time.Now()
+		if restoreOpts.at != "" {
+			var err error
+			at, err = parseRestoreTime(restoreOpts.at)
+			if err != nil {
+				l.WithError(err).Panic("Failed to parse --at")
+			}
+		}
+		b, err := store.Find(m.SourceNamespace, args[0], at)
+		if err != nil {
+			l.WithError(err).Error("Failed to find backup")
+			os.Exit(1)
+		}
+		l.WithField("backup", b.Path).WithField("time", b.Time).Info("Restoring backup")
+		m.SourcePVCName = args[0]
+		m.ArchivePath = filepath.Join(restoreOpts.dir, b.Path)
+		m.Force = restoreOpts.force
+		m.DestPVCStorageClass = restoreOpts.pvcNewStorageClass
+		m.DestPVCSize = restoreOpts.pvcNewSize
+		m.DestPVCAccessModes = restoreOpts.pvcNewAccessModes
+		m.ImportMode = validImportMode(restoreOpts.importMode)
+		m.Ownership = restoreOpts.ownership
+		m.Encryption = restoreOpts.encryption.key()
+		err = m.Run()
+		if err != nil {
+			l.WithError(err).Error("Failed to restore backup")
+			os.Exit(1)
+		}
+	},
+}
+
+func parseRestoreTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither RFC3339 nor a date", raw)
+	}
+	return day.Add(24*time.Hour - time.Second), nil
+}
+
+func printBackups(store backup.Store, namespace string, pvc string) {
+	backups, err := store.List()
+	if err != nil {
+		log.WithError(err).Panic("Failed to list backups")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSIZE\tPATH")
+	for _, b := range backups {
+		if b.Namespace != namespace || b.PVC != pvc {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", b.Time.Format(time.RFC3339), b.Size, b.Path)
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.Flags().StringVar(&restoreOpts.dir, "dir", "", "Directory the backups are stored in.")
+	restoreCmd.Flags().StringVarP(&restoreOpts.namespace, "namespace", "n", "", "Namespace of the PVC. If empty, the namespace from your kubeconfig file will be used.")
+	restoreCmd.Flags().StringVar(&restoreOpts.at, "at", "", "Restore the newest backup taken at or before this time. If empty, the newest backup is restored.")
+	restoreCmd.Flags().BoolVar(&restoreOpts.list, "list", false, "Only list the available backups of the PVC.")
+	restoreCmd.Flags().BoolVar(&restoreOpts.force, "force", false, "Restore even if the PVC is used by pods.")
+	restoreCmd.Flags().BoolVar(&restoreOpts.tolerateAllNodes, "tolerate-any-node", false, "Allow job to tolerating any node node taints.")
+	restoreCmd.Flags().StringVar(&restoreOpts.pvcNewStorageClass, "new-pvc-storage-class", "", "Storage class to use if the PVC is created. If empty, the storage class from the backup is used.")
+	restoreCmd.Flags().StringVar(&restoreOpts.pvcNewSize, "new-pvc-size", "", "Size to use if the PVC is created. If empty, the size from the backup is used.")
+	restoreCmd.Flags().StringSliceVar(&restoreOpts.pvcNewAccessModes, "new-pvc-access-mode", []string{}, "Access mode(s) to use if the PVC is created. If empty, the access modes from the backup are used.")
+	addOwnershipFlags(restoreCmd, &restoreOpts.ownership)
+	restoreCmd.Flags().StringVar(&restoreOpts.importMode, "import-mode", strategies.ImportModeMerge, "How existing data on the PVC is handled: merge extracts on top of it, replace deletes it (except lost+found) first, empty-only refuses to restore into a PVC which isn't empty.")
+	addEncryptionFlags(restoreCmd, &restoreOpts.encryption)
+	restoreCmd.Flags().StringVar(&config.ContainerImage, "container-image", config.ContainerImage, "Image to use for moving jobs")
+	_ = restoreCmd.MarkFlagRequired("dir")
+}
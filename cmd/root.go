@@ -5,41 +5,78 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
 
+	"beryju.org/korb/v2/pkg/archive"
 	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/kube"
 	"beryju.org/korb/v2/pkg/migrator"
+	"beryju.org/korb/v2/pkg/mover"
+	"beryju.org/korb/v2/pkg/strategies"
 
 	"github.com/spf13/cobra"
-	"k8s.io/client-go/util/homedir"
 )
 
 var (
 	kubeConfig      string
+	kubeContext     string
+	kubeCluster     string
+	kubeUser        string
+	asUser          string
+	asGroups        []string
 	sourceNamespace string
 	strategy        string
 )
 
+var (
+	destKubeConfig string
+	destContext    string
+)
+
 var (
 	pvcNewStorageClass string
 	pvcNewSize         string
 	pvcNewName         string
 	pvcNewNamespace    string
 	pvcNewAccessModes  []string
+	sourceSubPath      string
+	destSubPath        string
 )
 
 var (
 	debug            bool
 	force            bool
+	skipUsageCheck   bool
 	skipWaitPVCBind  bool
 	tolerateAllNodes bool
+	retainSourcePV   bool
+	offlineResize    bool
+	archivePath      string
 	timeout          string
 	copyTimeout      string
 )
 
+var (
+	encryption encryptionOptions
+	splitSize  string
+	importMode string
+)
+
+var (
+	rsyncOptions mover.RsyncOptions
+	filter       mover.Filter
+	ownership    mover.Ownership
+	dryRun       bool
+	mappings     []string
+)
+
 var Version string
 
 // rootCmd represents the base command when called without any subcommands
@@ -47,14 +84,22 @@ var rootCmd = &cobra.Command{
 	Use:     "korb [pvc [pvc]]",
 	Version: Version,
 	Long:    `Move data between Kubernetes PVCs on different Storage Classes.`,
-	Args:    cobra.MinimumNArgs(1),
-	Run:     rootCmdRun,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(mappings) > 0 {
+			// All PVCs are given by the mappings
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if debug {
+			log.SetLevel(log.DebugLevel)
+		}
+	},
+	Run: rootCmdRun,
 }
 
 func rootCmdRun(cmd *cobra.Command, args []string) {
-	if debug {
-		log.SetLevel(log.DebugLevel)
-	}
 
 	var t *time.Duration
 	if timeout != "" {
@@ -76,10 +121,34 @@ func rootCmdRun(cmd *cobra.Command, args []string) {
 		cT = &_cT
 	}
 
+	if archivePath != "" && len(args) > 1 {
+		log.Panic("--archive can only be used when migrating a single PVC")
+	}
+
+	parsedMappings := parseMappings()
+	if len(parsedMappings) > 0 {
+		// The mappings are migrated together, using the first source PVC to validate the migration
+		args = []string{parsedMappings[0].Source.PVC}
+	}
+
+	failed := false
 	for _, pvc := range args {
-		m := migrator.New(cmd.Context(), kubeConfig, strategy, tolerateAllNodes)
+		m := migrator.New(cmd.Context(), kubeOptions(), strategy, tolerateAllNodes)
 		m.Force = force
-		m.WaitForTempDestPVCBind = skipWaitPVCBind
+		m.SkipUsageCheck = skipUsageCheck
+		m.WaitForTempDestPVCBind = !skipWaitPVCBind
+		m.RetainSourcePV = retainSourcePV
+		m.OfflineResize = offlineResize
+		m.ArchivePath = archivePath
+		m.Encryption = encryption.key()
+		m.SplitSize = archiveSplitSize(splitSize)
+		m.ImportMode = validImportMode(importMode)
+		m.RsyncOptions = rsyncOptions
+		m.Filter = filter
+		m.Ownership = ownership
+		m.SourceSubPath = sourceSubPath
+		m.DestSubPath = destSubPath
+		m.Mappings = parsedMappings
 		m.Timeout = t
 		m.CopyTimeout = cT
 
@@ -91,9 +160,22 @@ func rootCmdRun(cmd *cobra.Command, args []string) {
 			m.SourceNamespace = sourceNamespace
 			m.DestNamespace = sourceNamespace
 		}
-		// if pvcNewNamespace != "" {
-		// 	m.DestNamespace = pvcNewNamespace
-		// }
+		// Across clusters the data is streamed through korb, so the destination
+		// namespace can be chosen freely
+		if destKubeConfig != "" || destContext != "" {
+			// Cluster, user and impersonation flags apply to both clusters
+			opts := kubeOptions()
+			opts.Context = destContext
+			if destKubeConfig != "" {
+				opts.KubeConfig = destKubeConfig
+			}
+			if err := m.SetDestinationCluster(opts); err != nil {
+				log.WithError(err).Panic("Failed to create destination client")
+			}
+			if pvcNewNamespace != "" {
+				m.DestNamespace = pvcNewNamespace
+			}
+		}
 
 		m.DestPVCSize = pvcNewSize
 		m.DestPVCStorageClass = pvcNewStorageClass
@@ -101,19 +183,162 @@ func rootCmdRun(cmd *cobra.Command, args []string) {
 		m.DestPVCAccessModes = pvcNewAccessModes
 
 		m.SourcePVCName = pvc
-		m.Run()
+		if dryRun {
+			err := printDryRun(m)
+			if err != nil {
+				log.WithError(err).WithField("pvc", pvc).Error("Failed to run dry-run")
+				failed = true
+			}
+			continue
+		}
+		err := m.Run()
+		if err != nil {
+			log.WithError(err).WithField("pvc", pvc).Error("Failed to migrate")
+			failed = true
+		}
+		if cmd.Context().Err() != nil {
+			break
+		}
 		if len(args) > 1 {
 			fmt.Println("=====================")
 		}
 	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printDryRun shows which top-level paths of the source are copied with the configured filters.
+func printDryRun(m *migrator.Migrator) error {
+	matches, err := m.DryRun()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(m.Mappings) > 0 {
+		fmt.Fprintf(w, "MAPPING\tPATH\tSTATUS\n")
+		for _, match := range matches {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", match.Mapping, match.Path, match.Status)
+		}
+		return w.Flush()
+	}
+	fmt.Fprintf(w, "PATH\tSTATUS\n")
+	for _, match := range matches {
+		fmt.Fprintf(w, "%s\t%s\n", match.Path, match.Status)
+	}
+	return w.Flush()
+}
+
+func kubeOptions() kube.Options {
+	return kube.Options{
+		KubeConfig:        kubeConfig,
+		Context:           kubeContext,
+		Cluster:           kubeCluster,
+		User:              kubeUser,
+		Impersonate:       asUser,
+		ImpersonateGroups: asGroups,
+	}
+}
+
+// encryptionOptions are the flags added by addEncryptionFlags
+type encryptionOptions struct {
+	keyFile        string
+	passphraseFile string
+}
+
+// key loads the key archives are encrypted with, from a key file, a passphrase file
+// or the KORB_ENCRYPTION_PASSPHRASE environment variable. Returns nil if no key is configured.
+func (o encryptionOptions) key() *archive.Key {
+	passphrase := os.Getenv("KORB_ENCRYPTION_PASSPHRASE")
+	if o.keyFile != "" && (o.passphraseFile != "" || passphrase != "") {
+		log.Panic("Only one of --encryption-key-file and a passphrase can be used")
+	}
+	var key *archive.Key
+	var err error
+	switch {
+	case o.keyFile != "":
+		key, err = archive.KeyFromFile(o.keyFile)
+	case o.passphraseFile != "":
+		var raw []byte
+		raw, err = os.ReadFile(o.passphraseFile)
+		if err == nil {
+			key, err = archive.KeyFromPassphrase(strings.TrimRight(string(raw), "\r\n"))
+		}
+	case passphrase != "":
+		key, err = archive.KeyFromPassphrase(passphrase)
+	}
+	if err != nil {
+		log.WithError(err).Panic("Failed to load encryption key")
+	}
+	return key
+}
+
+// archiveSplitSize parses --split-size, and returns 0 if exported archives shouldn't be split.
+func archiveSplitSize(splitSize string) int64 {
+	if splitSize == "" {
+		return 0
+	}
+	q, err := resource.ParseQuantity(splitSize)
+	if err != nil {
+		log.WithError(err).Panic("Failed to parse split size")
+	}
+	if q.Value() <= 0 {
+		log.WithField("split-size", splitSize).Panic("Split size must be positive")
+	}
+	return q.Value()
+}
+
+// parseMappings parses all --map flags before anything is started.
+func parseMappings() []mover.Mapping {
+	parsed := make([]mover.Mapping, 0, len(mappings))
+	for _, raw := range mappings {
+		m, err := mover.ParseMapping(raw)
+		if err != nil {
+			log.WithError(err).Panic("Failed to parse mapping")
+		}
+		parsed = append(parsed, m)
+	}
+	return parsed
+}
+
+// validImportMode checks --import-mode before anything is started.
+func validImportMode(importMode string) string {
+	if !slices.Contains(strategies.ImportModes, importMode) {
+		log.WithField("import-mode", importMode).Panicf("Unknown import mode, expected one of %s", strings.Join(strategies.ImportModes, ", "))
+	}
+	return importMode
+}
+
+func addEncryptionFlags(cmd *cobra.Command, o *encryptionOptions) {
+	cmd.Flags().StringVar(&o.keyFile, "encryption-key-file", "", "Encrypt exported archives with the key in this file, and decrypt imported archives. The file should contain at least 32 random bytes.")
+	cmd.Flags().StringVar(&o.passphraseFile, "encryption-passphrase-file", "", "Encrypt exported archives with the passphrase in this file, and decrypt imported archives. The passphrase can also be set with KORB_ENCRYPTION_PASSPHRASE.")
+}
+
+func addOwnershipFlags(cmd *cobra.Command, ownership *mover.Ownership) {
+	cmd.Flags().StringVar(&ownership.Chown, "chown", "", "Set the owner of all copied or imported files, as numeric uid:gid, uid or :gid.")
+	cmd.Flags().StringVar(&ownership.Chmod, "chmod", "", "Change the permissions of all copied or imported files, as an octal mode or symbolic modes like u+rwX,g+rX.")
+	cmd.Flags().StringArrayVar(&ownership.UIDMap, "uid-map", []string{}, "Change the owner of files owned by one uid to another, as numeric old:new. Can be repeated.")
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	ctx, cncl := signal.NotifyContext(context.Background(), os.Kill, os.Interrupt)
+	ctx, cncl := context.WithCancel(context.Background())
 	defer cncl()
 
+	// The first interrupt cancels the context, which stops the current stage. Cleanup and rollback
+	// use a separate context (see config.CleanupContext) so they still run. A second interrupt exits immediately.
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Warning("Interrupted, stopping current stage and cleaning up. Interrupt again to exit immediately.")
+		cncl()
+		<-signals
+		log.Error("Interrupted again, exiting without cleaning up. Run 'korb cleanup' to remove leftover objects.")
+		os.Exit(130)
+	}()
+
 	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -123,26 +348,50 @@ func Execute() {
 func init() {
 	log.SetLevel(log.InfoLevel)
 
-	if home := homedir.HomeDir(); home != "" {
-		rootCmd.Flags().StringVar(&kubeConfig, "kube-config", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
-	} else {
-		rootCmd.Flags().StringVar(&kubeConfig, "kube-config", "", "absolute path to the kubeconfig file")
-	}
-	rootCmd.Flags().BoolVar(&debug, "debug", false, "enable debug logging")
+	rootCmd.PersistentFlags().StringVar(&kubeConfig, "kube-config", "", "(optional) path to the kubeconfig file. If empty, the files from KUBECONFIG or ~/.kube/config are used, or the service account when running in a pod.")
+	rootCmd.PersistentFlags().StringVar(&kubeContext, "context", "", "The kubeconfig context to use.")
+	rootCmd.PersistentFlags().StringVar(&kubeCluster, "cluster", "", "The kubeconfig cluster to use.")
+	rootCmd.PersistentFlags().StringVar(&kubeUser, "user", "", "The kubeconfig user to use.")
+	rootCmd.PersistentFlags().StringVar(&asUser, "as", "", "Username to impersonate.")
+	rootCmd.PersistentFlags().StringSliceVar(&asGroups, "as-group", []string{}, "Group to impersonate, can be repeated to specify multiple groups.")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug logging")
 	rootCmd.Flags().StringVar(&sourceNamespace, "source-namespace", "", "Namespace where the old PVCs reside. If empty, the namespace from your kubeconfig file will be used.")
 
+	rootCmd.Flags().StringVar(&sourceSubPath, "source-subpath", "", "Only copy or export this directory of the source PVC, relative to its root. Not supported by copy-twice-name, which deletes the source PVC.")
+	rootCmd.Flags().StringVar(&destSubPath, "dest-subpath", "", "Copy or import into this directory of the new PVC, relative to its root. The directory is created if it doesn't exist.")
+	rootCmd.Flags().StringArrayVar(&mappings, "map", []string{}, "Copy a directory of one PVC into a directory of another, as pvc[:subdir]=pvc[:subdir], with the remap strategy. Can be repeated to merge multiple PVCs into one or split one PVC into several. No PVCs are given as arguments when mappings are used.")
 	rootCmd.Flags().StringVar(&pvcNewStorageClass, "new-pvc-storage-class", "", "Storage class to use for the new PVC. If empty, the storage class of the source will be used.")
 	rootCmd.Flags().StringVar(&pvcNewName, "new-pvc-name", "", "Name for the new PVC. If empty, same name will be reused.")
 	rootCmd.Flags().StringVar(&pvcNewSize, "new-pvc-size", "", "Size for the new PVC. If empty, the size of the source will be used. Accepts formats like used in Kubernetes Manifests (Gi, Ti, ...)")
-	rootCmd.Flags().StringVar(&pvcNewNamespace, "new-pvc-namespace", "", "Namespace for the new PVCs to be created in. If empty, the namespace from your kubeconfig file will be used.")
+	rootCmd.Flags().StringVar(&pvcNewNamespace, "new-pvc-namespace", "", "Namespace for the new PVCs to be created in. Only supported with --dest-kube-config or --dest-context, otherwise the source namespace is used.")
+	rootCmd.Flags().StringVar(&destKubeConfig, "dest-kube-config", "", "Path to the kubeconfig file of the cluster to create the new PVC in. If only --dest-context is set, the same kubeconfig as for the source is used.")
+	rootCmd.Flags().StringVar(&destContext, "dest-context", "", "Context of the cluster to create the new PVC in. If empty, the current context of the destination kubeconfig is used.")
 	rootCmd.Flags().StringSliceVar(&pvcNewAccessModes, "new-pvc-access-mode", []string{}, "Access mode(s) for the new PVC. If empty, the access mode of the source will be used. Accepts formats like used in Kubernetes Manifests (ReadWriteOnce, ReadWriteMany, ...)")
 
 	rootCmd.Flags().BoolVar(&force, "force", false, "Ignore warning which would normally halt the tool during validation.")
-	rootCmd.Flags().BoolVar(&skipWaitPVCBind, "skip-pvc-bind-wait", false, "Skip waiting for PVC to be bound.")
+	rootCmd.Flags().BoolVar(&skipUsageCheck, "skip-usage-check", false, "Skip measuring the used space on the source PVC to check that it fits into the destination.")
+	rootCmd.Flags().BoolVar(&skipWaitPVCBind, "skip-pvc-bind-wait", false, "Skip waiting for PVC to be bound. Not required for storage classes with WaitForFirstConsumer binding, which are detected automatically.")
 	rootCmd.Flags().BoolVar(&tolerateAllNodes, "tolerate-any-node", false, "Allow job to tolerating any node node taints.")
+	rootCmd.Flags().BoolVar(&retainSourcePV, "retain-source-pv", true, "Set the reclaim policy of the source PV to Retain before deleting the source PVC. Use 'korb prune-retained' to remove retained PVs.")
+
+	rootCmd.Flags().BoolVar(&rsyncOptions.Delete, "rsync-delete", false, "Delete files in the destination which don't exist in the source, for example when copying into a PVC which isn't empty.")
+	rootCmd.Flags().BoolVar(&rsyncOptions.Sparse, "rsync-sparse", false, "Keep sparse files sparse in the destination.")
+	rootCmd.Flags().BoolVar(&rsyncOptions.Xattrs, "rsync-xattrs", false, "Copy extended attributes.")
+	rootCmd.Flags().BoolVar(&rsyncOptions.NumericIDs, "rsync-numeric-ids", false, "Keep numeric uids and gids instead of mapping them by user and group name.")
+
+	addOwnershipFlags(rootCmd, &ownership)
+
+	rootCmd.Flags().StringArrayVar(&filter.Include, "include", []string{}, "Only copy, export or import paths matching this pattern, relative to the source subpath or the root of the volume (for example data or logs/*.log). Can be repeated.")
+	rootCmd.Flags().StringArrayVar(&filter.Exclude, "exclude", []string{}, "Don't copy, export or import paths matching this pattern, relative to the source subpath or the root of the volume (for example cache or lost+found). Can be repeated.")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only validate the migration and show which top-level paths are copied with the --include and --exclude patterns.")
 
+	rootCmd.Flags().BoolVar(&offlineResize, "offline-resize", false, "For the expand strategy, scale down all controllers using the PVC while it is resized. Required for storage drivers which don't support online expansion.")
+	rootCmd.Flags().StringVar(&archivePath, "archive", "", "Path of the archive for the export and import strategies. If empty, <pvc>.tar in the current directory is used.")
+	rootCmd.Flags().StringVar(&splitSize, "split-size", "", "Split exported archives into chunks of this size (Mi, Gi, ...), which are listed with their checksums in <archive>.manifest.json. If empty, archives aren't split.")
+	rootCmd.Flags().StringVar(&importMode, "import-mode", strategies.ImportModeMerge, "How the import strategy handles existing data: merge extracts on top of it, replace deletes it (except lost+found) first, empty-only refuses to import into a volume which isn't empty.")
+	addEncryptionFlags(rootCmd, &encryption)
 	rootCmd.Flags().StringVar(&config.ContainerImage, "container-image", config.ContainerImage, "Image to use for moving jobs")
-	rootCmd.Flags().StringVar(&strategy, "strategy", "", "Strategy to use, by default will try to auto-select")
+	rootCmd.Flags().StringVar(&strategy, "strategy", "", "Strategy to use, by default will try to auto-select. Run 'korb strategies' to list all strategies.")
 	rootCmd.Flags().StringVar(&timeout, "timeout", "", "Overwrite auto-generated timeout (by default 60s for Pod to start, copy timeout is based on PVC size)")
 	rootCmd.Flags().StringVar(&copyTimeout, "copyTimeout", "", "Overwrite auto-generated copy timeout (by default 60s/GB of volume data)")
 
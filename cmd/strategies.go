@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"beryju.org/korb/v2/pkg/strategies"
+)
+
+var strategiesCmd = &cobra.Command{
+	Use:   "strategies",
+	Short: "List all available strategies",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "IDENTIFIER\tDESCRIPTION")
+		for _, s := range strategies.StrategyInstances(strategies.NewBaseStrategy(&strategies.BaseStrategyOpts{})) {
+			fmt.Fprintf(w, "%s\t%s\n", s.Identifier(), s.Description())
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(strategiesCmd)
+}
@@ -1,6 +1,28 @@
 #!/bin/bash -xe
 if [[ $1 == "sync" ]]; then
-    rsync -aHA --progress /source/ /dest
+    # Additional rsync options are passed by korb as separate arguments
+    rsync -aHA --progress "${@:2}" /source/ /dest
+elif [[ $1 == "sync-map" ]]; then
+    # rsync options, followed by -- and pairs of source and destination directories
+    shift
+    opts=()
+    while [[ $# -gt 0 && $1 != "--" ]]; do
+        opts+=("$1")
+        shift
+    done
+    shift
+    # Check all sources before anything is copied
+    for ((i = 1; i <= $#; i += 2)); do
+        if [[ ! -d ${!i} ]]; then
+            echo "Source directory ${!i} does not exist"
+            exit 1
+        fi
+    done
+    while [[ $# -gt 0 ]]; do
+        mkdir -p "$2"
+        rsync -aHA --progress "${opts[@]}" "$1/" "$2"
+        shift 2
+    done
 elif [[ $1 == "sleep" ]]; then
     cat
 else
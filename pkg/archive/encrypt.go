@@ -0,0 +1,326 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Encrypted archives start with a header, followed by chunks of at most chunkSize bytes of
+// plaintext, each sealed with AES-256-GCM:
+//
+//	header: magic (8) | kdf (1) | iterations (4) | salt (16) | nonce (12) | key check (32)
+//	chunk:  final (1) | length (4) | ciphertext (length)
+//
+// The nonce of each chunk is the nonce of the header XOR the chunk counter. The header and
+// whether the chunk is the last one are authenticated with every chunk, so reordered,
+// modified or truncated archives are detected.
+var magic = []byte("KORBENC1")
+
+const (
+	kdfKeyFile    byte = 1
+	kdfPassphrase byte = 2
+
+	pbkdf2Iterations = 600_000
+	saltSize         = 16
+	checkSize        = 32
+	headerSize       = 8 + 1 + 4 + saltSize + 12 + checkSize
+	chunkSize        = 64 * 1024
+	minKeyFileSize   = 16
+)
+
+var (
+	// ErrWrongKey is returned when an archive was encrypted with a different key or passphrase
+	ErrWrongKey = errors.New("wrong key or passphrase for encrypted archive")
+	// ErrTruncated is returned when an encrypted archive ends before its last chunk
+	ErrTruncated = errors.New("encrypted archive is truncated")
+	// ErrKeyRequired is returned when reading an encrypted archive without a key
+	ErrKeyRequired = errors.New("archive is encrypted, but no key or passphrase was given")
+)
+
+// Key is the secret archives are encrypted with, either the contents of a key file or a passphrase.
+type Key struct {
+	kdf    byte
+	secret []byte
+}
+
+// KeyFromFile reads a key file. The file should contain at least 32 random bytes,
+// for example created with `head -c 32 /dev/urandom > korb.key`.
+func KeyFromFile(path string) (*Key, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) < minKeyFileSize {
+		return nil, fmt.Errorf("key file %s is too short, expected at least %d bytes", path, minKeyFileSize)
+	}
+	return &Key{kdf: kdfKeyFile, secret: raw}, nil
+}
+
+// KeyFromPassphrase creates a key from a passphrase, which is stretched with PBKDF2.
+func KeyFromPassphrase(passphrase string) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase cannot be empty")
+	}
+	return &Key{kdf: kdfPassphrase, secret: []byte(passphrase)}, nil
+}
+
+func (k *Key) derive(salt []byte, iterations uint32) (cipher.AEAD, []byte, error) {
+	master := k.secret
+	if k.kdf == kdfPassphrase {
+		var err error
+		master, err = pbkdf2.Key(sha256.New, string(k.secret), salt, int(iterations), 32)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	encKey, err := hkdf.Key(sha256.New, master, salt, "korb archive encryption", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	check, err := hkdf.Key(sha256.New, master, salt, "korb archive key check", checkSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, check, nil
+}
+
+type header struct {
+	raw   []byte
+	nonce []byte
+}
+
+func (h header) chunkNonce(counter uint64) []byte {
+	nonce := bytes.Clone(h.nonce)
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], counter)
+	subtle.XORBytes(nonce[4:], nonce[4:], c[:])
+	return nonce
+}
+
+func (h header) additionalData(final bool) []byte {
+	flag := byte(0)
+	if final {
+		flag = 1
+	}
+	return append(bytes.Clone(h.raw), flag)
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  header
+	buf     []byte
+	counter uint64
+}
+
+// NewWriter returns a writer which encrypts everything written to it into w. Close must be
+// called to write the last chunk, otherwise the archive can't be decrypted.
+func NewWriter(w io.Writer, key *Key) (io.WriteCloser, error) {
+	salt := make([]byte, saltSize)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	iterations := uint32(0)
+	if key.kdf == kdfPassphrase {
+		iterations = pbkdf2Iterations
+	}
+	aead, check, err := key.derive(salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 0, headerSize)
+	raw = append(raw, magic...)
+	raw = append(raw, key.kdf)
+	raw = binary.BigEndian.AppendUint32(raw, iterations)
+	raw = append(raw, salt...)
+	raw = append(raw, nonce...)
+	raw = append(raw, check...)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	return &writer{
+		w:      w,
+		aead:   aead,
+		header: header{raw: raw, nonce: nonce},
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (e *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(chunkSize-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(e.buf) == chunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (e *writer) seal(final bool) error {
+	ciphertext := e.aead.Seal(nil, e.header.chunkNonce(e.counter), e.buf, e.header.additionalData(final))
+	e.counter++
+	e.buf = e.buf[:0]
+	prefix := []byte{0}
+	if final {
+		prefix[0] = 1
+	}
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(len(ciphertext)))
+	if _, err := e.w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := e.w.Write(ciphertext)
+	return err
+}
+
+// Close writes the last chunk. It doesn't close the underlying writer.
+func (e *writer) Close() error {
+	return e.seal(true)
+}
+
+type reader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  header
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+// IsEncrypted checks whether r starts with the header of an encrypted archive, without consuming it.
+func IsEncrypted(r *bufio.Reader) (bool, error) {
+	peek, err := r.Peek(len(magic))
+	if errors.Is(err, io.EOF) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(peek, magic), nil
+}
+
+// NewReader reads the header of an encrypted archive from r, and returns a reader which
+// decrypts the archive. ErrWrongKey is returned if the archive was encrypted with a different key.
+func NewReader(r io.Reader, key *Key) (io.Reader, error) {
+	raw := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if !bytes.Equal(raw[:len(magic)], magic) {
+		return nil, errors.New("archive is not encrypted")
+	}
+	if key == nil {
+		return nil, ErrKeyRequired
+	}
+	kdf := raw[8]
+	if kdf != key.kdf {
+		if kdf == kdfPassphrase {
+			return nil, errors.New("archive was encrypted with a passphrase, not a key file")
+		}
+		return nil, errors.New("archive was encrypted with a key file, not a passphrase")
+	}
+	iterations := binary.BigEndian.Uint32(raw[9:13])
+	// The header isn't authenticated before the key is derived, so a modified iteration
+	// count must neither make the derivation arbitrarily slow nor weaken it
+	expected := uint32(0)
+	if kdf == kdfPassphrase {
+		expected = pbkdf2Iterations
+	}
+	if iterations != expected {
+		return nil, fmt.Errorf("unexpected key derivation iterations %d, expected %d", iterations, expected)
+	}
+	salt := raw[13 : 13+saltSize]
+	nonce := raw[13+saltSize : 13+saltSize+12]
+	aead, check, err := key.derive(salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(check, raw[headerSize-checkSize:]) != 1 {
+		return nil, ErrWrongKey
+	}
+	return &reader{
+		r:      r,
+		aead:   aead,
+		header: header{raw: raw, nonce: nonce},
+	}, nil
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *reader) open() error {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, prefix); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+	final := prefix[0] == 1
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > chunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("encrypted archive is corrupted, chunk too large")
+	}
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(d.r, ciphertext); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+	plaintext, err := d.aead.Open(nil, d.header.chunkNonce(d.counter), ciphertext, d.header.additionalData(final))
+	if err != nil {
+		return fmt.Errorf("encrypted archive is corrupted: chunk %d failed authentication", d.counter)
+	}
+	d.counter++
+	d.buf = plaintext
+	if final {
+		d.done = true
+		// Nothing may follow the last chunk
+		if n, _ := d.r.Read(make([]byte, 1)); n > 0 {
+			return errors.New("encrypted archive is corrupted, data after last chunk")
+		}
+	}
+	return nil
+}
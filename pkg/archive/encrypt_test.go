@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyFile(t *testing.T) *Key {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "korb.key")
+	if err := os.WriteFile(path, []byte(rand.Text()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := KeyFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testPassphrase(t *testing.T, passphrase string) *Key {
+	t.Helper()
+	key, err := KeyFromPassphrase(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encrypt(t *testing.T, key *Key, plaintext []byte) []byte {
+	t.Helper()
+	out := &bytes.Buffer{}
+	w, err := NewWriter(out, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decrypt(encrypted []byte, key *Key) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(encrypted), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// splitChunks splits an encrypted archive into its header and its chunks, including their prefixes.
+func splitChunks(t *testing.T, encrypted []byte) ([]byte, [][]byte) {
+	t.Helper()
+	header, rest := encrypted[:headerSize], encrypted[headerSize:]
+	chunks := [][]byte{}
+	for len(rest) > 0 {
+		if len(rest) < 5 {
+			t.Fatalf("incomplete chunk prefix")
+		}
+		size := 5 + int(binary.BigEndian.Uint32(rest[1:5]))
+		chunks = append(chunks, rest[:size])
+		rest = rest[size:]
+	}
+	return header, chunks
+}
+
+func join(header []byte, chunks ...[]byte) []byte {
+	return bytes.Join(append([][]byte{header}, chunks...), nil)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keyFile := testKeyFile(t)
+	passphrase := testPassphrase(t, "correct horse battery staple")
+	for _, tc := range []struct {
+		name   string
+		key    *Key
+		size   int
+		chunks int
+	}{
+		{name: "empty", key: keyFile, size: 0, chunks: 1},
+		{name: "single byte", key: keyFile, size: 1, chunks: 1},
+		{name: "one chunk", key: keyFile, size: chunkSize - 1, chunks: 1},
+		{name: "exact chunk", key: keyFile, size: chunkSize, chunks: 2},
+		{name: "multiple chunks", key: keyFile, size: 3*chunkSize + 7, chunks: 4},
+		{name: "passphrase", key: passphrase, size: chunkSize + 1, chunks: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plaintext := make([]byte, tc.size)
+			_, _ = rand.Read(plaintext)
+			encrypted := encrypt(t, tc.key, plaintext)
+			if _, chunks := splitChunks(t, encrypted); len(chunks) != tc.chunks {
+				t.Errorf("got %d chunks, expected %d", len(chunks), tc.chunks)
+			}
+			decrypted, err := decrypt(encrypted, tc.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Error("decrypted archive doesn't match the plaintext")
+			}
+		})
+	}
+}
+
+func TestDecryptKey(t *testing.T) {
+	keyFile := testKeyFile(t)
+	passphrase := testPassphrase(t, "correct horse battery staple")
+	byKeyFile := encrypt(t, keyFile, []byte("data"))
+	byPassphrase := encrypt(t, passphrase, []byte("data"))
+	for _, tc := range []struct {
+		name      string
+		encrypted []byte
+		key       *Key
+		wantErr   error
+	}{
+		{name: "other key file", encrypted: byKeyFile, key: testKeyFile(t), wantErr: ErrWrongKey},
+		{name: "other passphrase", encrypted: byPassphrase, key: testPassphrase(t, "wrong"), wantErr: ErrWrongKey},
+		{name: "passphrase for key file", encrypted: byKeyFile, key: passphrase},
+		{name: "key file for passphrase", encrypted: byPassphrase, key: keyFile},
+		{name: "no key", encrypted: byKeyFile, key: nil, wantErr: ErrKeyRequired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decrypt(tc.encrypted, tc.key)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, expected %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptModified(t *testing.T) {
+	key := testKeyFile(t)
+	plaintext := make([]byte, 2*chunkSize+1)
+	_, _ = rand.Read(plaintext)
+	encrypted := encrypt(t, key, plaintext)
+	header, chunks := splitChunks(t, encrypted)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, expected 3", len(chunks))
+	}
+	iterations := bytes.Clone(header)
+	binary.BigEndian.PutUint32(iterations[9:13], 1)
+	for _, tc := range []struct {
+		name      string
+		encrypted []byte
+		wantErr   error
+	}{
+		{name: "missing last chunk", encrypted: join(header, chunks[0], chunks[1]), wantErr: ErrTruncated},
+		{name: "truncated chunk", encrypted: encrypted[:len(encrypted)-1], wantErr: ErrTruncated},
+		{name: "header only", encrypted: header, wantErr: ErrTruncated},
+		{name: "reordered chunks", encrypted: join(header, chunks[1], chunks[0], chunks[2])},
+		{name: "last chunk first", encrypted: join(header, chunks[2])},
+		{name: "extra trailing chunk", encrypted: join(header, chunks[0], chunks[1], chunks[2], chunks[2])},
+		{name: "trailing byte", encrypted: append(bytes.Clone(encrypted), 0)},
+		{name: "modified iterations", encrypted: join(iterations, chunks...)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decrypt(tc.encrypted, key)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Errorf("got error %v, expected %v", err, tc.wantErr)
+			}
+		})
+	}
+}
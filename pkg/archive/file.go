@@ -0,0 +1,458 @@
+package archive
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ManifestSuffix is appended to the path of an archive for the path of its manifest
+const ManifestSuffix = ".manifest.json"
+
+// ErrNoManifest is returned when verifying an archive which has no manifest, for example
+// because it was created by an older version of korb
+var ErrNoManifest = errors.New("archive has no manifest")
+
+// Manifest is written next to every archive, and is used to verify the archive before it is imported.
+// Split archives are stored as chunks next to the manifest, as <archive>.<generation>.000, <archive>.<generation>.001, ...
+// The generation is unique to each write, so writing an archive never overwrites the chunks of the archive it replaces.
+type Manifest struct {
+	// Size and SHA256 of the complete archive, as it is stored
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Chunks of the archive, in order. Empty if the archive is stored as a single file
+	Chunks []Chunk `json:"chunks,omitempty"`
+	// Source is the PVC the archive was exported from
+	Source *Source `json:"source,omitempty"`
+}
+
+// Source describes the PVC an archive was exported from, so it can be recreated when importing
+// into a namespace where it doesn't exist.
+type Source struct {
+	Namespace    string   `json:"namespace"`
+	Name         string   `json:"name"`
+	StorageClass string   `json:"storageClass,omitempty"`
+	Size         string   `json:"size,omitempty"`
+	AccessModes  []string `json:"accessModes,omitempty"`
+}
+
+type Chunk struct {
+	// Name of the chunk file, in the same directory as the manifest
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestPath returns the path of the manifest of the archive at path.
+func ManifestPath(path string) string {
+	return path + ManifestSuffix
+}
+
+func chunkName(path string, generation string, idx int) string {
+	return fmt.Sprintf("%s.%s.%03d", filepath.Base(path), generation, idx)
+}
+
+// ReadManifest reads the manifest of the archive at path.
+func ReadManifest(path string) (*Manifest, error) {
+	raw, err := os.ReadFile(ManifestPath(path))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(raw, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	for _, c := range m.Chunks {
+		// Chunks are always next to the manifest, anything else could point at other files
+		if c.Name == "" || c.Name == "." || c.Name == ".." || filepath.Base(c.Name) != c.Name {
+			return nil, fmt.Errorf("invalid chunk name '%s' in manifest", c.Name)
+		}
+	}
+	return m, nil
+}
+
+// writeTemp writes the manifest to a temporary file next to the manifest of path, and returns its name.
+func (m *Manifest) writeTemp(path string) (string, error) {
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	tmp := ManifestPath(path) + ".tmp"
+	err = os.WriteFile(tmp, raw, 0o640)
+	if err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+// Exists checks whether an archive exists at path, either as a single file or as chunks with a manifest.
+func Exists(path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return true
+	}
+	_, err := os.Stat(ManifestPath(path))
+	return err == nil
+}
+
+// Remove deletes the archive at path, including its manifest and chunks.
+func Remove(path string) error {
+	errs := []error{}
+	if m, err := ReadManifest(path); err == nil {
+		for _, c := range m.Chunks {
+			errs = append(errs, removeIfExists(filepath.Join(filepath.Dir(path), c.Name)))
+		}
+	}
+	errs = append(errs, removeIfExists(ManifestPath(path)), removeIfExists(path))
+	return errors.Join(errs...)
+}
+
+func removeIfExists(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// FileWriter writes an archive to the local disk, and its manifest on Close. If SplitSize is set,
+// the archive is split into chunks of at most SplitSize bytes. The archive and its chunks are written
+// to temporary files, which only replace an existing archive at the same path on Close, once
+// the new archive is complete.
+type FileWriter struct {
+	// Source is stored in the manifest, if set before Close
+	Source *Source
+
+	path      string
+	splitSize int64
+	// generation is part of the names of the chunks
+	generation string
+
+	current     *os.File
+	currentHash hash.Hash
+	currentSize int64
+
+	hash     hash.Hash
+	size     int64
+	manifest *Manifest
+	// temporary files, in the order of the chunks of the manifest
+	files []string
+}
+
+func NewFileWriter(path string, splitSize int64) (*FileWriter, error) {
+	w := &FileWriter{
+		path:       path,
+		splitSize:  splitSize,
+		generation: rand.Text()[:8],
+		hash:       sha256.New(),
+		manifest:   &Manifest{},
+	}
+	if splitSize > 0 {
+		return w, nil
+	}
+	file, err := w.createTemp()
+	if err != nil {
+		return nil, err
+	}
+	w.current = file
+	return w, nil
+}
+
+// createTemp creates a temporary file next to the archive, so it can be renamed on Close.
+func (w *FileWriter) createTemp() (*os.File, error) {
+	file, err := os.CreateTemp(filepath.Dir(w.path), "korb-mover-")
+	if err != nil {
+		return nil, err
+	}
+	w.files = append(w.files, file.Name())
+	return file, nil
+}
+
+func (w *FileWriter) Write(p []byte) (int, error) {
+	if w.splitSize <= 0 {
+		n, err := w.current.Write(p)
+		w.hash.Write(p[:n])
+		w.size += int64(n)
+		return n, err
+	}
+	written := 0
+	for len(p) > 0 {
+		if w.current == nil || w.currentSize >= w.splitSize {
+			if err := w.nextChunk(); err != nil {
+				return written, err
+			}
+		}
+		n := int(min(int64(len(p)), w.splitSize-w.currentSize))
+		n, err := w.current.Write(p[:n])
+		w.currentHash.Write(p[:n])
+		w.hash.Write(p[:n])
+		w.currentSize += int64(n)
+		w.size += int64(n)
+		written += n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (w *FileWriter) nextChunk() error {
+	if err := w.finishChunk(); err != nil {
+		return err
+	}
+	file, err := w.createTemp()
+	if err != nil {
+		return err
+	}
+	w.current = file
+	w.currentHash = sha256.New()
+	w.currentSize = 0
+	return nil
+}
+
+func (w *FileWriter) finishChunk() error {
+	if w.current == nil {
+		return nil
+	}
+	err := w.current.Close()
+	if err != nil {
+		return err
+	}
+	w.manifest.Chunks = append(w.manifest.Chunks, Chunk{
+		Name:   chunkName(w.path, w.generation, len(w.manifest.Chunks)),
+		Size:   w.currentSize,
+		SHA256: hex.EncodeToString(w.currentHash.Sum(nil)),
+	})
+	w.current = nil
+	return nil
+}
+
+// Close finishes the archive, and replaces an existing archive at the same path. If it fails,
+// the new archive is removed, and an existing archive is kept.
+func (w *FileWriter) Close() error {
+	err := w.close()
+	if err != nil {
+		w.Abort()
+	}
+	return err
+}
+
+func (w *FileWriter) close() error {
+	if w.splitSize <= 0 {
+		if err := w.current.Close(); err != nil {
+			return err
+		}
+		w.current = nil
+	} else {
+		if w.current == nil {
+			// Always write at least one chunk, even for empty archives
+			if err := w.nextChunk(); err != nil {
+				return err
+			}
+		}
+		if err := w.finishChunk(); err != nil {
+			return err
+		}
+	}
+	w.manifest.Source = w.Source
+	w.manifest.Size = w.size
+	w.manifest.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	manifest, err := w.manifest.writeTemp(w.path)
+	if err != nil {
+		return err
+	}
+	w.files = append(w.files, manifest)
+	return w.replace(manifest)
+}
+
+// replace moves the complete archive into place, and then removes the parts of an older archive at
+// the same path. The manifest is moved last, so until then the older archive is still complete.
+func (w *FileWriter) replace(manifest string) error {
+	old, _ := ReadManifest(w.path)
+	dir := filepath.Dir(w.path)
+	if len(w.manifest.Chunks) == 0 {
+		if err := os.Rename(w.files[0], w.path); err != nil {
+			return err
+		}
+	}
+	for idx, c := range w.manifest.Chunks {
+		name := filepath.Join(dir, c.Name)
+		if err := os.Rename(w.files[idx], name); err != nil {
+			return err
+		}
+		// Chunks are only part of the archive once the manifest is in place, until then Abort removes them
+		w.files[idx] = name
+	}
+	if err := os.Rename(manifest, ManifestPath(w.path)); err != nil {
+		return err
+	}
+	w.files = nil
+	errs := []error{}
+	if len(w.manifest.Chunks) > 0 {
+		errs = append(errs, removeIfExists(w.path))
+	}
+	if old != nil {
+		for _, c := range old.Chunks {
+			if slices.ContainsFunc(w.manifest.Chunks, func(n Chunk) bool { return n.Name == c.Name }) {
+				continue
+			}
+			errs = append(errs, removeIfExists(filepath.Join(dir, c.Name)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Abort removes everything written so far, so no partial archive is left behind. An existing
+// archive at the same path is kept.
+func (w *FileWriter) Abort() {
+	if w.current != nil {
+		_ = w.current.Close()
+	}
+	for _, f := range w.files {
+		_ = removeIfExists(f)
+	}
+}
+
+// Verify checks the size and hash of the archive, and of all of its chunks, against its manifest.
+// ErrNoManifest is returned if the archive has no manifest.
+func Verify(path string) error {
+	m, err := ReadManifest(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNoManifest
+	}
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size := int64(0)
+	if len(m.Chunks) == 0 {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		size, err = io.Copy(h, file)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range m.Chunks {
+		file, err := os.Open(filepath.Join(filepath.Dir(path), c.Name))
+		if err != nil {
+			return err
+		}
+		ch := sha256.New()
+		n, err := io.Copy(io.MultiWriter(h, ch), file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if err := c.check(n, ch); err != nil {
+			return err
+		}
+		size += n
+	}
+	if size != m.Size {
+		return fmt.Errorf("archive has %d bytes, expected %d", size, m.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != m.SHA256 {
+		return fmt.Errorf("archive has checksum %s, expected %s", sum, m.SHA256)
+	}
+	return nil
+}
+
+func (c Chunk) check(size int64, h hash.Hash) error {
+	if size != c.Size {
+		return fmt.Errorf("chunk %s has %d bytes, expected %d", c.Name, size, c.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != c.SHA256 {
+		return fmt.Errorf("chunk %s has checksum %s, expected %s", c.Name, sum, c.SHA256)
+	}
+	return nil
+}
+
+// Open opens the archive at path for reading. Split archives are read chunk by chunk,
+// and the hash of each chunk is checked before its last byte is returned.
+func Open(path string) (io.ReadCloser, error) {
+	m, err := ReadManifest(path)
+	if err != nil || len(m.Chunks) == 0 {
+		return os.Open(path)
+	}
+	return &chunkReader{
+		dir:    filepath.Dir(path),
+		chunks: m.Chunks,
+	}, nil
+}
+
+type chunkReader struct {
+	dir    string
+	chunks []Chunk
+
+	current     *os.File
+	currentHash hash.Hash
+	currentSize int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		// The last byte of a chunk would be lost
+		return 0, nil
+	}
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(filepath.Join(r.dir, r.chunks[0].Name))
+			if err != nil {
+				return 0, err
+			}
+			r.current = file
+			r.currentHash = sha256.New()
+			r.currentSize = 0
+		}
+		// Never return the complete chunk before its hash was checked
+		limit := r.chunks[0].Size - r.currentSize - 1
+		if limit > 0 {
+			n, err := r.current.Read(p[:min(int64(len(p)), limit)])
+			r.currentHash.Write(p[:n])
+			r.currentSize += int64(n)
+			if errors.Is(err, io.EOF) {
+				return n, r.chunks[0].check(r.currentSize, r.currentHash)
+			}
+			return n, err
+		}
+		rest, err := io.ReadAll(r.current)
+		if err != nil {
+			return 0, err
+		}
+		r.currentHash.Write(rest)
+		r.currentSize += int64(len(rest))
+		if err := r.chunks[0].check(r.currentSize, r.currentHash); err != nil {
+			return 0, err
+		}
+		r.current.Close()
+		r.current = nil
+		r.chunks = r.chunks[1:]
+		if len(rest) > 0 {
+			return copy(p, rest), nil
+		}
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+func writeArchive(t *testing.T, path string, splitSize int64, data []byte) {
+	t.Helper()
+	w, err := NewFileWriter(path, splitSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// files returns the names of all files in dir.
+func files(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// chunkPath returns the path of a chunk of the archive at path, as listed in its manifest.
+func chunkPath(t *testing.T, path string, idx int) string {
+	t.Helper()
+	m, err := ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(filepath.Dir(path), m.Chunks[idx].Name)
+}
+
+func TestFileWriterSplit(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		splitSize int64
+		size      int
+		chunks    int
+	}{
+		{name: "not split", splitSize: 0, size: 10, chunks: 0},
+		{name: "not split empty", splitSize: 0, size: 0, chunks: 0},
+		{name: "split empty", splitSize: 4, size: 0, chunks: 1},
+		{name: "split size 1", splitSize: 1, size: 3, chunks: 3},
+		{name: "smaller than split size", splitSize: 4, size: 3, chunks: 1},
+		{name: "exact split size", splitSize: 4, size: 4, chunks: 1},
+		{name: "exact multiple of split size", splitSize: 4, size: 12, chunks: 3},
+		{name: "one byte over multiple", splitSize: 4, size: 13, chunks: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "archive.tar")
+			data := make([]byte, tc.size)
+			_, _ = rand.Read(data)
+			writeArchive(t, path, tc.splitSize, data)
+
+			m, err := ReadManifest(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Chunks) != tc.chunks {
+				t.Errorf("got %d chunks, expected %d", len(m.Chunks), tc.chunks)
+			}
+			for _, c := range m.Chunks {
+				if tc.splitSize > 0 && c.Size > tc.splitSize {
+					t.Errorf("chunk %s has %d bytes, more than the split size", c.Name, c.Size)
+				}
+			}
+			// Only the archive or its chunks and the manifest are left, no temporary files
+			if got := len(files(t, dir)); got != max(tc.chunks, 1)+1 {
+				t.Errorf("got %d files, expected %d: %v", got, max(tc.chunks, 1)+1, files(t, dir))
+			}
+			if err := Verify(path); err != nil {
+				t.Errorf("failed to verify archive: %v", err)
+			}
+			r, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if err := iotest.TestReader(r, data); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestVerifyModified(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		splitSize int64
+		modify    func(t *testing.T, path string)
+	}{
+		{
+			name: "modified archive",
+			modify: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, []byte("modified"), 0o640); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:      "modified chunk",
+			splitSize: 4,
+			modify: func(t *testing.T, path string) {
+				if err := os.WriteFile(chunkPath(t, path, 1), []byte("abcd"), 0o640); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:      "truncated chunk",
+			splitSize: 4,
+			modify: func(t *testing.T, path string) {
+				if err := os.Truncate(chunkPath(t, path, 2), 1); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:      "missing chunk",
+			splitSize: 4,
+			modify: func(t *testing.T, path string) {
+				if err := os.Remove(chunkPath(t, path, 1)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "archive.tar")
+			writeArchive(t, path, tc.splitSize, []byte("0123456789ab"))
+			tc.modify(t, path)
+			if err := Verify(path); err == nil {
+				t.Error("expected verify to fail")
+			}
+			r, err := Open(path)
+			if err != nil {
+				return
+			}
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err == nil && bytes.Equal(data, []byte("0123456789ab")) {
+				t.Error("expected reading the modified archive to fail")
+			}
+		})
+	}
+}
+
+func TestFileWriterReplace(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		oldSplit int64
+		newSplit int64
+	}{
+		{name: "fewer chunks", oldSplit: 2, newSplit: 4},
+		{name: "same number of chunks", oldSplit: 4, newSplit: 3},
+		{name: "single file to chunks", oldSplit: 0, newSplit: 4},
+		{name: "chunks to single file", oldSplit: 2, newSplit: 0},
+		{name: "single file to single file", oldSplit: 0, newSplit: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "archive.tar")
+			writeArchive(t, path, tc.oldSplit, []byte("old archive!"))
+			old, err := ReadManifest(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeArchive(t, path, tc.newSplit, []byte("new data"))
+			m, err := ReadManifest(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Only the new archive is left, and none of its chunks reused a name of the old chunks
+			want := []string{"archive.tar"}
+			if len(m.Chunks) > 0 {
+				want = []string{}
+				for _, c := range m.Chunks {
+					if slices.ContainsFunc(old.Chunks, func(o Chunk) bool { return o.Name == c.Name }) {
+						t.Errorf("chunk %s has the name of a chunk of the old archive", c.Name)
+					}
+					want = append(want, c.Name)
+				}
+			}
+			want = append(want, "archive.tar.manifest.json")
+			slices.Sort(want)
+			if got := files(t, dir); !slices.Equal(got, want) {
+				t.Errorf("got files %v, expected %v", got, want)
+			}
+			if err := Verify(path); err != nil {
+				t.Errorf("failed to verify archive: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.tar")
+	writeArchive(t, path, 4, []byte("old archive!"))
+	w, err := NewFileWriter(path, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+	if err := Verify(path); err != nil {
+		t.Errorf("existing archive was changed: %v", err)
+	}
+	if got := len(files(t, dir)); got != 4 {
+		t.Errorf("got %d files, expected the 3 chunks and manifest of the existing archive: %v", got, files(t, dir))
+	}
+}
+
+func TestReadManifestChunkNames(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		chunk string
+		valid bool
+	}{
+		{name: "base name", chunk: "archive.tar.ABCDEFGH.000", valid: true},
+		{name: "empty", chunk: ""},
+		{name: "current directory", chunk: "."},
+		{name: "parent directory", chunk: ".."},
+		{name: "relative path", chunk: "../other.tar"},
+		{name: "subdirectory", chunk: "sub/archive.tar.000"},
+		{name: "absolute path", chunk: "/etc/passwd"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "archive.tar")
+			raw := fmt.Sprintf(`{"size":1,"sha256":"","chunks":[{"name":%q,"size":1,"sha256":""}]}`, tc.chunk)
+			if err := os.WriteFile(ManifestPath(path), []byte(raw), 0o640); err != nil {
+				t.Fatal(err)
+			}
+			_, err := ReadManifest(path)
+			if tc.valid && err != nil {
+				t.Errorf("expected chunk name to be valid: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("expected chunk name to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FilterTar reads a gzip compressed tar archive, and returns an uncompressed tar archive which only
+// contains the entries for which keep returns true. Entry names are passed as stored, such as "./a/b".
+func FilterTar(in io.Reader, keep func(name string) bool) io.ReadCloser {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(filterTar(in, w, keep))
+	}()
+	return r
+}
+
+func filterTar(in io.Reader, out io.Writer, keep func(name string) bool) error {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	tw := tar.NewWriter(out)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !keep(hdr.Name) {
+			continue
+		}
+		// Sparse files are read with their holes filled, and can't be written as sparse files
+		if hdr.Typeflag == tar.TypeGNUSparse {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// TopLevel returns the names of all top-level entries of a gzip compressed tar archive.
+func TopLevel(in io.Reader) ([]string, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	seen := map[string]struct{}{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		seen[strings.Split(name, "/")[0]] = struct{}{}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
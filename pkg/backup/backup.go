@@ -0,0 +1,247 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"beryju.org/korb/v2/pkg/archive"
+)
+
+// TimeFormat is used in the file names of backups
+const TimeFormat = "20060102T150405Z"
+
+// IndexFile is written to the root of the backup directory, and lists all backups
+const IndexFile = "index.json"
+
+const archiveExtension = ".tar"
+
+// Backup is a single archive of a PVC. Backups are stored as <dir>/<namespace>/<pvc>/<time>.tar, split archives
+// as <time>.tar.manifest.json with chunks next to it.
+type Backup struct {
+	Namespace string    `json:"namespace"`
+	PVC       string    `json:"pvc"`
+	Time      time.Time `json:"time"`
+	// Path of the archive, relative to the backup directory
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type Index struct {
+	Updated time.Time `json:"updated"`
+	Backups []Backup  `json:"backups"`
+}
+
+// Retention decides which backups of a PVC are kept. A backup is kept if it matches any of the rules.
+type Retention struct {
+	// KeepLast keeps the newest n backups
+	KeepLast int
+	// KeepDaily keeps the newest backup of each of the last n days which have a backup
+	KeepDaily int
+	// KeepWeekly keeps the newest backup of each of the last n weeks which have a backup
+	KeepWeekly int
+}
+
+// Store manages the backups in a directory, for example a volume mounted into a CronJob.
+type Store struct {
+	Dir string
+}
+
+// NewPath returns the path a new backup of the PVC should be written to. Backups are never
+// overwritten, so an error is returned if a backup of the PVC was already written in the same second.
+func (s Store) NewPath(namespace string, pvc string, t time.Time) (string, error) {
+	dir := filepath.Join(s.Dir, namespace, pvc)
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, t.UTC().Format(TimeFormat)+archiveExtension)
+	if archive.Exists(path) {
+		return "", fmt.Errorf("backup %s already exists", path)
+	}
+	return path, nil
+}
+
+// List returns all backups in the directory, sorted by namespace and PVC, newest first.
+func (s Store) List() ([]Backup, error) {
+	backups := make([]Backup, 0)
+	seen := map[string]struct{}{}
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// Split archives only have a manifest and chunks, but are listed by the path of the archive
+		name := strings.TrimSuffix(d.Name(), archive.ManifestSuffix)
+		if !strings.HasSuffix(name, archiveExtension) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 {
+			return nil
+		}
+		t, err := time.Parse(TimeFormat, strings.TrimSuffix(parts[2], archiveExtension))
+		if err != nil {
+			// Not a backup, for example a partial archive
+			return nil
+		}
+		if _, ok := seen[rel]; ok {
+			return nil
+		}
+		seen[rel] = struct{}{}
+		size, err := archiveSize(filepath.Join(s.Dir, rel))
+		if err != nil {
+			return err
+		}
+		backups = append(backups, Backup{
+			Namespace: parts[0],
+			PVC:       parts[1],
+			Time:      t,
+			Path:      rel,
+			Size:      size,
+		})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return backups, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		if backups[i].Namespace != backups[j].Namespace {
+			return backups[i].Namespace < backups[j].Namespace
+		}
+		if backups[i].PVC != backups[j].PVC {
+			return backups[i].PVC < backups[j].PVC
+		}
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// WriteIndex writes the list of all backups to the index file in the backup directory.
+func (s Store) WriteIndex() error {
+	backups, err := s.List()
+	if err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(Index{
+		Updated: time.Now().UTC(),
+		Backups: backups,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.Dir, "."+IndexFile)
+	err = os.WriteFile(tmp, raw, 0o640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.Dir, IndexFile))
+}
+
+// Find returns the newest backup of the PVC which was taken at or before at.
+func (s Store) Find(namespace string, pvc string, at time.Time) (*Backup, error) {
+	backups, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backups {
+		if b.Namespace != namespace || b.PVC != pvc {
+			continue
+		}
+		if !b.Time.After(at) {
+			return &b, nil
+		}
+	}
+	return nil, fmt.Errorf("no backup of %s/%s found at or before %s", namespace, pvc, at.Format(time.RFC3339))
+}
+
+// Prune deletes all backups which aren't kept by the retention policy, and returns them.
+func (s Store) Prune(r Retention) ([]Backup, error) {
+	if r.KeepLast <= 0 && r.KeepDaily <= 0 && r.KeepWeekly <= 0 {
+		// Without any rule, all backups are kept
+		return []Backup{}, nil
+	}
+	backups, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	byPVC := map[string][]Backup{}
+	for _, b := range backups {
+		k := b.Namespace + "/" + b.PVC
+		byPVC[k] = append(byPVC[k], b)
+	}
+	pruned := make([]Backup, 0)
+	for _, pvcBackups := range byPVC {
+		for _, b := range r.expired(pvcBackups) {
+			err := archive.Remove(filepath.Join(s.Dir, b.Path))
+			if err != nil {
+				return pruned, err
+			}
+			pruned = append(pruned, b)
+		}
+	}
+	return pruned, nil
+}
+
+// archiveSize returns the size of the archive, for split archives the size of all chunks.
+func archiveSize(path string) (int64, error) {
+	if info, err := os.Stat(path); err == nil {
+		return info.Size(), nil
+	}
+	m, err := archive.ReadManifest(path)
+	if err != nil {
+		return 0, err
+	}
+	return m.Size, nil
+}
+
+// expired returns the backups which aren't kept, backups must be sorted newest first.
+func (r Retention) expired(backups []Backup) []Backup {
+	keep := make([]bool, len(backups))
+	bucket := func(n int, key func(t time.Time) string) {
+		seen := map[string]struct{}{}
+		for i, b := range backups {
+			if len(seen) >= n {
+				return
+			}
+			k := key(b.Time)
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			keep[i] = true
+		}
+	}
+	for i := 0; i < r.KeepLast && i < len(backups); i++ {
+		keep[i] = true
+	}
+	bucket(r.KeepDaily, func(t time.Time) string {
+		return t.UTC().Format(time.DateOnly)
+	})
+	bucket(r.KeepWeekly, func(t time.Time) string {
+		year, week := t.UTC().ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+	expired := make([]Backup, 0)
+	for i, b := range backups {
+		if !keep[i] {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"beryju.org/korb/v2/pkg/archive"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// testBackups are sorted newest first, as returned by List
+var testBackups = []Backup{
+	{Time: at("2026-10-19T12:00:00Z")}, // 0: Monday, week 43
+	{Time: at("2026-10-19T06:00:00Z")}, // 1: Monday, week 43
+	{Time: at("2026-10-18T12:00:00Z")}, // 2: Sunday, week 42
+	{Time: at("2026-10-17T12:00:00Z")}, // 3: Saturday, week 42
+	{Time: at("2026-10-12T12:00:00Z")}, // 4: Monday, week 42
+	{Time: at("2026-10-11T12:00:00Z")}, // 5: Sunday, week 41
+	{Time: at("2026-10-01T12:00:00Z")}, // 6: Thursday, week 40
+}
+
+func TestRetentionExpired(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		retention Retention
+		kept      []int
+	}{
+		{name: "no rules", retention: Retention{}, kept: []int{}},
+		{name: "keep last", retention: Retention{KeepLast: 2}, kept: []int{0, 1}},
+		{name: "keep last more than backups", retention: Retention{KeepLast: 10}, kept: []int{0, 1, 2, 3, 4, 5, 6}},
+		{name: "keep daily", retention: Retention{KeepDaily: 3}, kept: []int{0, 2, 3}},
+		{name: "keep weekly", retention: Retention{KeepWeekly: 2}, kept: []int{0, 2}},
+		{name: "keep weekly more than weeks", retention: Retention{KeepWeekly: 10}, kept: []int{0, 2, 5, 6}},
+		{name: "combined", retention: Retention{KeepLast: 1, KeepDaily: 2, KeepWeekly: 3}, kept: []int{0, 2, 5}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expired := tc.retention.expired(testBackups)
+			kept := []int{}
+			for i, b := range testBackups {
+				if !slices.ContainsFunc(expired, func(e Backup) bool { return e.Time.Equal(b.Time) }) {
+					kept = append(kept, i)
+				}
+			}
+			if !slices.Equal(kept, tc.kept) {
+				t.Errorf("kept backups %v, expected %v", kept, tc.kept)
+			}
+			if len(expired)+len(kept) != len(testBackups) {
+				t.Errorf("got %d expired backups, expected %d", len(expired), len(testBackups)-len(kept))
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	write := func(pvc string, ts time.Time, splitSize int64) {
+		path, err := s.NewPath("default", pvc, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := archive.NewFileWriter(path, splitSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("data", testBackups[0].Time, 0)
+	write("data", testBackups[2].Time, 0)
+	// Split archives are only listed by their manifest
+	write("data", testBackups[4].Time, 1)
+	write("other", testBackups[1].Time, 0)
+
+	for _, tc := range []struct {
+		name string
+		pvc  string
+		at   time.Time
+		want *time.Time
+	}{
+		{name: "newest", pvc: "data", at: at("2026-10-20T00:00:00Z"), want: &testBackups[0].Time},
+		{name: "exact time", pvc: "data", at: testBackups[2].Time, want: &testBackups[2].Time},
+		{name: "between backups", pvc: "data", at: at("2026-10-19T00:00:00Z"), want: &testBackups[2].Time},
+		{name: "split archive", pvc: "data", at: at("2026-10-13T00:00:00Z"), want: &testBackups[4].Time},
+		{name: "before first backup", pvc: "data", at: at("2026-10-01T00:00:00Z")},
+		{name: "other PVC", pvc: "other", at: at("2026-10-20T00:00:00Z"), want: &testBackups[1].Time},
+		{name: "unknown PVC", pvc: "unknown", at: at("2026-10-20T00:00:00Z")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := s.Find("default", tc.pvc, tc.at)
+			if tc.want == nil {
+				if err == nil {
+					t.Errorf("expected no backup, got %s", b.Path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !b.Time.Equal(*tc.want) {
+				t.Errorf("got backup from %s, expected %s", b.Time, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewPathExists(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	for _, splitSize := range []int64{0, 1} {
+		ts := testBackups[splitSize].Time
+		path, err := s.NewPath("default", "data", ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := archive.NewFileWriter(path, splitSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Backups in the same second would overwrite each other
+		if _, err := s.NewPath("default", "data", ts.Add(500*time.Millisecond)); err == nil {
+			t.Errorf("expected an error for an existing backup with split size %d", splitSize)
+		}
+		if _, err := s.NewPath("default", "data", ts.Add(time.Second)); err != nil {
+			t.Errorf("expected a new path one second later: %v", err)
+		}
+	}
+}
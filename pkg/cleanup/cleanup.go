@@ -0,0 +1,290 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/kube"
+)
+
+type Kind string
+
+const (
+	KindJob Kind = "Job"
+	KindPod Kind = "Pod"
+	KindPVC Kind = "PersistentVolumeClaim"
+)
+
+// Leftover is an object which was created by korb and was not removed, usually because
+// a migration was interrupted.
+type Leftover struct {
+	Kind        Kind
+	Namespace   string
+	Name        string
+	MigrationID string
+	Created     time.Time
+	State       string
+
+	// Safe is true if the object can be deleted without losing data. If not, Reason explains why.
+	Safe   bool
+	Reason string
+}
+
+func (l Leftover) Age() time.Duration {
+	return time.Since(l.Created).Round(time.Second)
+}
+
+// Objects created by korb versions which didn't label them are matched by name
+var (
+	legacyJobName = regexp.MustCompile(`^korb-job-[0-9a-f-]+$`)
+	legacyPVCName = regexp.MustCompile(`^(.+)-copy-\d+$`)
+)
+
+type Finder struct {
+	// Namespace to search in, all namespaces if empty
+	Namespace string
+	// MinAge is the age below which objects are considered to belong to a running migration
+	MinAge time.Duration
+
+	client *kubernetes.Clientset
+	ctx    context.Context
+	log    *log.Entry
+}
+
+func NewFinder(ctx context.Context, client *kubernetes.Clientset) *Finder {
+	return &Finder{
+		client: client,
+		ctx:    ctx,
+		log:    log.WithField("component", "cleanup"),
+	}
+}
+
+// Find returns all leftover objects, sorted by kind (jobs first, as deleting them also removes their pods).
+func (f *Finder) Find() ([]Leftover, error) {
+	leftovers := make([]Leftover, 0)
+	jobs, err := f.findJobs()
+	if err != nil {
+		return nil, err
+	}
+	leftovers = append(leftovers, jobs...)
+	pods, err := f.findPods()
+	if err != nil {
+		return nil, err
+	}
+	leftovers = append(leftovers, pods...)
+	pvcs, err := f.findPVCs()
+	if err != nil {
+		return nil, err
+	}
+	leftovers = append(leftovers, pvcs...)
+	return leftovers, nil
+}
+
+func isKorbManaged(meta metav1.ObjectMeta, legacyName *regexp.Regexp) bool {
+	return isLabelled(meta) || legacyName.MatchString(meta.Name)
+}
+
+func isLabelled(meta metav1.ObjectMeta) bool {
+	return meta.Labels[config.LabelManagedBy] == config.ManagedByKorb
+}
+
+func (f *Finder) newLeftover(kind Kind, meta metav1.ObjectMeta, state string) Leftover {
+	l := Leftover{
+		Kind:        kind,
+		Namespace:   meta.Namespace,
+		Name:        meta.Name,
+		MigrationID: meta.Labels[config.LabelMigrationID],
+		Created:     meta.CreationTimestamp.Time,
+		State:       state,
+		Safe:        true,
+	}
+	if l.Age() < f.MinAge {
+		l.Safe = false
+		l.Reason = "younger than minimum age, migration might still be running"
+	}
+	return l
+}
+
+func (f *Finder) findJobs() ([]Leftover, error) {
+	jobs, err := f.client.BatchV1().Jobs(f.Namespace).List(f.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	leftovers := make([]Leftover, 0)
+	for _, job := range jobs.Items {
+		if !isKorbManaged(job.ObjectMeta, legacyJobName) {
+			continue
+		}
+		leftovers = append(leftovers, f.newLeftover(KindJob, job.ObjectMeta, jobState(job)))
+	}
+	return leftovers, nil
+}
+
+func jobState(job batchv1.Job) string {
+	switch {
+	case job.Status.Active > 0:
+		return "Active"
+	case job.Status.Succeeded > 0:
+		return "Succeeded"
+	case job.Status.Failed > 0:
+		return "Failed"
+	}
+	return "Pending"
+}
+
+func (f *Finder) findPods() ([]Leftover, error) {
+	pods, err := f.client.CoreV1().Pods(f.Namespace).List(f.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	leftovers := make([]Leftover, 0)
+	for _, pod := range pods.Items {
+		managed := pod.Labels[config.LabelManagedBy] == config.ManagedByKorb ||
+			legacyJobName.MatchString(pod.Labels["job-name"])
+		if !managed {
+			continue
+		}
+		leftovers = append(leftovers, f.newLeftover(KindPod, pod.ObjectMeta, string(pod.Status.Phase)))
+	}
+	return leftovers, nil
+}
+
+func (f *Finder) findPVCs() ([]Leftover, error) {
+	pvcs, err := f.client.CoreV1().PersistentVolumeClaims(f.Namespace).List(f.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := f.client.CoreV1().Pods(f.Namespace).List(f.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	leftovers := make([]Leftover, 0)
+	for _, pvc := range pvcs.Items {
+		if !isKorbManaged(pvc.ObjectMeta, legacyPVCName) {
+			continue
+		}
+		l := f.newLeftover(KindPVC, pvc.ObjectMeta, string(pvc.Status.Phase))
+		if !l.Safe {
+			leftovers = append(leftovers, l)
+			continue
+		}
+		// A PVC of the user might have a name like a temporary PVC of an old korb version
+		if !isLabelled(pvc.ObjectMeta) {
+			l.Safe = false
+			l.Reason = "name matches legacy korb pattern, no korb labels"
+			leftovers = append(leftovers, l)
+			continue
+		}
+		if pod := mountedBy(pods.Items, pvc); pod != "" {
+			l.Safe = false
+			l.Reason = fmt.Sprintf("still mounted by pod %s", pod)
+			leftovers = append(leftovers, l)
+			continue
+		}
+		source := f.sourceOf(pvc)
+		reason, err := f.onlyCopyReason(pvc, source)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			l.Safe = false
+			l.Reason = reason
+			f.log.WithField("pvc", pvc.Namespace+"/"+pvc.Name).WithField("source", source).Warningf("Temporary PVC might be the only copy of its data: %s", reason)
+		}
+		leftovers = append(leftovers, l)
+	}
+	return leftovers, nil
+}
+
+// mountedBy returns the name of a pod which still mounts the PVC, or an empty string.
+func mountedBy(pods []v1.Pod, pvc v1.PersistentVolumeClaim) string {
+	for _, pod := range pods {
+		if pod.Namespace == pvc.Namespace && kube.MountsPVC(pod, pvc.Name) {
+			return pod.Name
+		}
+	}
+	return ""
+}
+
+// sourceOf returns the namespace/name of the PVC that the temporary PVC holds a copy of.
+func (f *Finder) sourceOf(pvc v1.PersistentVolumeClaim) string {
+	if source, ok := pvc.Annotations[config.AnnotationSourcePVC]; ok {
+		return source
+	}
+	match := legacyPVCName.FindStringSubmatch(pvc.Name)
+	if match == nil {
+		return ""
+	}
+	return pvc.Namespace + "/" + match[1]
+}
+
+// onlyCopyReason checks whether the source PVC of a temporary PVC still holds the data,
+// and returns why it might not, or an empty string if the temporary PVC is safe to delete.
+func (f *Finder) onlyCopyReason(pvc v1.PersistentVolumeClaim, source string) (string, error) {
+	parts := strings.SplitN(source, "/", 2)
+	if len(parts) != 2 {
+		return "source PVC unknown", nil
+	}
+	sourcePVC, err := f.client.CoreV1().PersistentVolumeClaims(parts[0]).Get(f.ctx, parts[1], metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return fmt.Sprintf("only copy, source PVC %s does not exist", source), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	id := pvc.Labels[config.LabelMigrationID]
+	if id != "" && sourcePVC.Labels[config.LabelMigrationID] == id {
+		return fmt.Sprintf("only complete copy, %s was recreated by the same migration and might be incomplete", source), nil
+	}
+	return "", nil
+}
+
+// Delete removes the leftover object from the cluster. PVCs which are mounted by a pod are never deleted.
+func (l Leftover) Delete(ctx context.Context, client *kubernetes.Clientset) error {
+	policy := metav1.DeletePropagationForeground
+	opts := metav1.DeleteOptions{
+		PropagationPolicy: &policy,
+	}
+	var err error
+	switch l.Kind {
+	case KindJob:
+		err = client.BatchV1().Jobs(l.Namespace).Delete(ctx, l.Name, opts)
+	case KindPod:
+		err = client.CoreV1().Pods(l.Namespace).Delete(ctx, l.Name, opts)
+	case KindPVC:
+		err = l.checkUnmounted(ctx, client)
+		if err != nil {
+			return err
+		}
+		err = client.CoreV1().PersistentVolumeClaims(l.Namespace).Delete(ctx, l.Name, opts)
+	}
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// checkUnmounted returns an error if a pod mounts the PVC, as pods might have been started since
+// the leftover was found.
+func (l Leftover) checkUnmounted(ctx context.Context, client *kubernetes.Clientset) error {
+	pods, err := client.CoreV1().Pods(l.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	pvc := v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: l.Namespace, Name: l.Name}}
+	if pod := mountedBy(pods.Items, pvc); pod != "" {
+		return fmt.Errorf("PVC is still mounted by pod %s", pod)
+	}
+	return nil
+}
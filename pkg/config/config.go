@@ -1,3 +1,52 @@
 package config
 
-var ContainerImage = "ghcr.io/beryju/korb-mover:v2"
+import (
+	"context"
+	"time"
+)
+
+var ContainerImage = "ghcr.io/beryju/korb-mover:v3"
+
+// CleanupTimeout is how long cleanup and rollback steps may take after a migration was interrupted.
+var CleanupTimeout = 2 * time.Minute
+
+// CleanupContext returns a context for cleanup operations, which isn't cancelled when ctx is
+// cancelled (for example by Ctrl-C), but is limited to CleanupTimeout.
+func CleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), CleanupTimeout)
+}
+
+const (
+	// LabelManagedBy is set to ManagedByKorb on all temporary objects created by korb
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	ManagedByKorb  = "korb"
+	// LabelRole describes what a temporary object is used for, one of the Role constants.
+	LabelRole = "korb.beryju.org/role"
+	// LabelMigrationID is set on objects which are part of a migration, with the
+	// value being the ID of the migration.
+	LabelMigrationID = "korb.beryju.org/migration-id"
+
+	// AnnotationRetainedAt is set on source PersistentVolumes which were retained
+	// before a migration, the value is an RFC3339 timestamp.
+	AnnotationRetainedAt = "korb.beryju.org/retained-at"
+	// AnnotationOriginalReclaimPolicy holds the reclaim policy a retained
+	// PersistentVolume had before it was retained.
+	AnnotationOriginalReclaimPolicy = "korb.beryju.org/original-reclaim-policy"
+	// AnnotationSourcePVC holds the namespace/name of the PVC a retained
+	// PersistentVolume was bound to, or the PVC a temporary PVC holds a copy of.
+	AnnotationSourcePVC = "korb.beryju.org/source-pvc"
+)
+
+const (
+	RoleMover   = "mover"
+	RoleTempPVC = "temp-pvc"
+)
+
+// Labels returns the labels set on temporary objects korb creates for a migration.
+func Labels(migrationID string, role string) map[string]string {
+	return map[string]string{
+		LabelManagedBy:   ManagedByKorb,
+		LabelMigrationID: migrationID,
+		LabelRole:        role,
+	}
+}
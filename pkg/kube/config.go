@@ -0,0 +1,102 @@
+package kube
+
+import (
+	"os"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Options select which kubeconfig and context to connect with.
+type Options struct {
+	// KubeConfig is an explicit path to a kubeconfig file. If empty, the files in
+	// KUBECONFIG are merged, or ~/.kube/config is used.
+	KubeConfig string
+	Context    string
+	Cluster    string
+	User       string
+
+	Impersonate       string
+	ImpersonateGroups []string
+}
+
+// NewConfig loads the kubeconfig selected by opts and returns the client config
+// and the namespace of the selected context. If no kubeconfig is found, the in-cluster
+// config of the pod's service account is used.
+func NewConfig(opts Options) (*rest.Config, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if opts.KubeConfig != "" {
+		rules.ExplicitPath = opts.KubeConfig
+	} else if inCluster() {
+		// The deferred loader would fall back to the in-cluster config by itself, but without the
+		// impersonation overrides, so check for a kubeconfig before using it
+		raw, err := rules.Load()
+		if err == nil && clientcmdapi.IsConfigEmpty(raw) {
+			return newInClusterConfig(opts)
+		}
+	}
+	cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{
+			CurrentContext: opts.Context,
+			Context: clientcmdapi.Context{
+				Cluster:  opts.Cluster,
+				AuthInfo: opts.User,
+			},
+			AuthInfo: clientcmdapi.AuthInfo{
+				Impersonate:       opts.Impersonate,
+				ImpersonateGroups: opts.ImpersonateGroups,
+			},
+		})
+
+	// use the current context in kubeconfig, unless overwritten
+	config, err := cc.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	ns, _, err := cc.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	return config, ns, nil
+}
+
+// inCluster checks whether korb runs in a pod, with the environment variables set by the kubelet.
+func inCluster() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != ""
+}
+
+// newInClusterConfig is used when korb runs in a pod, for example as a Job.
+func newInClusterConfig(opts Options) (*rest.Config, string, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: opts.Impersonate,
+		Groups:   opts.ImpersonateGroups,
+	}
+	ns := "default"
+	if raw, err := os.ReadFile(serviceAccountNamespace); err == nil {
+		ns = strings.TrimSpace(string(raw))
+	}
+	return config, ns, nil
+}
+
+// NewClient is a shortcut for NewConfig which also creates the clientset.
+func NewClient(opts Options) (*kubernetes.Clientset, string, error) {
+	config, ns, err := NewConfig(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, ns, nil
+}
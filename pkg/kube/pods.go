@@ -0,0 +1,30 @@
+package kube
+
+import (
+	v1 "k8s.io/api/core/v1"
+
+	"beryju.org/korb/v2/pkg/config"
+)
+
+// UsesPVC checks whether the pod mounts the PVC with the given name. Finished pods don't use
+// the volume anymore, and korb's own movers are handled separately, so they are ignored.
+func UsesPVC(pod v1.Pod, name string) bool {
+	if pod.Labels[config.LabelManagedBy] == config.ManagedByKorb {
+		return false
+	}
+	return MountsPVC(pod, name)
+}
+
+// MountsPVC checks whether the pod mounts the PVC with the given name, including korb's own movers.
+// Finished pods don't use the volume anymore, so they are ignored.
+func MountsPVC(pod v1.Pod, name string) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == name {
+			return true
+		}
+	}
+	return false
+}
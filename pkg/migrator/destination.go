@@ -4,6 +4,8 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"beryju.org/korb/v2/pkg/config"
 )
 
 func (m *Migrator) GetDestPVCSize(fallback resource.Quantity) resource.Quantity {
@@ -33,11 +35,16 @@ func (m *Migrator) GetDestinationPVCTemplate(sourcePVC *v1.PersistentVolumeClaim
 	if m.DestPVCStorageClass != "" {
 		sc = &m.DestPVCStorageClass
 	}
+	labels := map[string]string{}
+	for k, v := range sourcePVC.Labels {
+		labels[k] = v
+	}
+	labels[config.LabelMigrationID] = m.MigrationID
 	destPVC := &v1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      m.SourcePVCName,
 			Namespace: m.DestNamespace,
-			Labels:    sourcePVC.Labels,
+			Labels:    labels,
 		},
 		Spec: v1.PersistentVolumeClaimSpec{
 			AccessModes: m.GetDestPVCAccessModes(sourcePVC.Spec.AccessModes),
@@ -0,0 +1,98 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"beryju.org/korb/v2/pkg/mover"
+	"beryju.org/korb/v2/pkg/strategies"
+)
+
+// PathMatch is a top-level path of the source, and whether it is copied with the configured filter.
+type PathMatch struct {
+	// Mapping is the mapping the path is copied by, if mappings are used
+	Mapping string `json:"mapping,omitempty"`
+	Path    string `json:"path"`
+	// Status is one of mover.FilterIncluded, mover.FilterExcluded or mover.FilterPartial
+	Status string `json:"status"`
+}
+
+// DryRun lists the top-level paths of the source, and whether they are copied with the configured filter.
+// With mappings, the paths of the source directory of each mapping are listed.
+// Nothing is changed, Prepare is called if it hasn't been called before.
+func (m *Migrator) DryRun() ([]PathMatch, error) {
+	if m.selected == nil {
+		err := m.Prepare()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(m.Mappings) > 0 {
+		return m.dryRunMappings()
+	}
+	var paths []string
+	var err error
+	if lister, ok := m.selected.(strategies.PathLister); ok {
+		paths, err = lister.ListPaths(m.sourcePVC)
+	} else {
+		paths, err = m.listSourcePaths(m.sourcePVC, m.SourceSubPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list paths: %w", err)
+	}
+	return m.classify("", paths), nil
+}
+
+// dryRunMappings lists the paths of the source of every mapping, the filter applies to each of them.
+func (m *Migrator) dryRunMappings() ([]PathMatch, error) {
+	matches := make([]PathMatch, 0)
+	for _, mapping := range m.Mappings {
+		sourcePVC, err := m.kClient.CoreV1().PersistentVolumeClaims(m.SourceNamespace).Get(m.ctx, mapping.Source.PVC, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get source PVC %s: %w", mapping.Source.PVC, err)
+		}
+		paths, err := m.listSourcePaths(sourcePVC, mapping.Source.SubPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list paths of %s: %w", mapping.Source, err)
+		}
+		matches = append(matches, m.classify(mapping.String(), paths)...)
+	}
+	return matches, nil
+}
+
+func (m *Migrator) classify(mapping string, paths []string) []PathMatch {
+	matches := make([]PathMatch, 0, len(paths))
+	for _, p := range paths {
+		matches = append(matches, PathMatch{
+			Mapping: mapping,
+			Path:    p,
+			Status:  m.Filter.Classify(p),
+		})
+	}
+	return matches
+}
+
+// listSourcePaths starts a mover on the source PVC and lists the top-level paths of subPath.
+func (m *Migrator) listSourcePaths(sourcePVC *v1.PersistentVolumeClaim, subPath string) ([]string, error) {
+	job := mover.NewMoverJob(m.ctx, m.kClient, mover.MoverTypeSleep, m.TolerateAllNodes)
+	job.Namespace = sourcePVC.Namespace
+	job.SourceVolume = sourcePVC
+	job.GenerateName = fmt.Sprintf("korb-dry-run-%s-", sourcePVC.UID)
+	job.MigrationID = m.MigrationID
+	defer job.Cleanup()
+
+	timeout := strategies.DefaultTimeout
+	if m.Timeout != nil {
+		timeout = *m.Timeout
+	}
+	pod := job.Start().WaitForRunning(timeout)
+	if pod == nil {
+		return nil, errors.New("dry-run mover did not start")
+	}
+	// The subpath isn't mounted, so listing a missing subpath fails instead of creating it
+	return job.ListPaths(*pod, m.kConfig, path.Join(mover.SourceMount, subPath), 1)
+}
@@ -0,0 +1,133 @@
+package migrator
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/kube"
+)
+
+// PVCInfo describes a PVC and the workloads using it, as shown by `korb list`.
+type PVCInfo struct {
+	Namespace           string   `json:"namespace"`
+	Name                string   `json:"name"`
+	StorageClass        string   `json:"storageClass"`
+	Size                string   `json:"size"`
+	AccessModes         []string `json:"accessModes"`
+	Volume              string   `json:"volume"`
+	Phase               string   `json:"phase"`
+	Pods                []string `json:"pods"`
+	Controllers         []string `json:"controllers"`
+	MigrationInProgress bool     `json:"migrationInProgress"`
+}
+
+// List returns all PVCs in the source namespace, together with the pods and top-level controllers using them.
+func (m *Migrator) List() ([]PVCInfo, error) {
+	pvcs, err := m.kClient.CoreV1().PersistentVolumeClaims(m.SourceNamespace).List(m.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	migrating, err := m.getMigratingPVCs(pvcs.Items)
+	if err != nil {
+		return nil, err
+	}
+	pvcPods, err := m.getPodsByPVC()
+	if err != nil {
+		return nil, err
+	}
+	// Pods of the same controller share their owners, which only have to be fetched once
+	m.ownerCache = map[string][]interface{}{}
+	defer func() {
+		m.ownerCache = nil
+	}()
+
+	infos := make([]PVCInfo, 0, len(pvcs.Items))
+	for _, pvc := range pvcs.Items {
+		info := PVCInfo{
+			Namespace:           pvc.Namespace,
+			Name:                pvc.Name,
+			Size:                pvc.Spec.Resources.Requests.Storage().String(),
+			AccessModes:         make([]string, 0, len(pvc.Spec.AccessModes)),
+			Volume:              pvc.Spec.VolumeName,
+			Phase:               string(pvc.Status.Phase),
+			Pods:                make([]string, 0),
+			Controllers:         make([]string, 0),
+			MigrationInProgress: migrating[pvc.Name],
+		}
+		if pvc.Spec.StorageClassName != nil {
+			info.StorageClass = *pvc.Spec.StorageClassName
+		}
+		for _, am := range pvc.Spec.AccessModes {
+			info.AccessModes = append(info.AccessModes, string(am))
+		}
+		pods := pvcPods[pvc.Name]
+		for _, pod := range pods {
+			info.Pods = append(info.Pods, pod.Name)
+		}
+		for _, controller := range m.getPodControllers(pods) {
+			info.Controllers = append(info.Controllers, ControllerName(controller))
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// getPodsByPVC lists the pods in the source namespace once, and returns the pods using each PVC
+// by the name of the PVC, with the same rules as getPVCPods.
+func (m *Migrator) getPodsByPVC() (map[string][]v1.Pod, error) {
+	nsPods, err := m.kClient.CoreV1().Pods(m.SourceNamespace).List(m.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pvcPods := map[string][]v1.Pod{}
+	for _, pod := range nsPods.Items {
+		claims := map[string]struct{}{}
+		for _, vol := range getPVCs(pod.Spec.Volumes) {
+			claims[vol.PersistentVolumeClaim.ClaimName] = struct{}{}
+		}
+		for claim := range claims {
+			if kube.UsesPVC(pod, claim) {
+				pvcPods[claim] = append(pvcPods[claim], pod)
+			}
+		}
+	}
+	return pvcPods, nil
+}
+
+// getMigratingPVCs returns the names of PVCs which are currently used by a korb mover job,
+// which have a temporary copy, or which are being created by a migration.
+func (m *Migrator) getMigratingPVCs(pvcs []v1.PersistentVolumeClaim) (map[string]bool, error) {
+	migrating := map[string]bool{}
+	jobs, err := m.kClient.BatchV1().Jobs(m.SourceNamespace).List(m.ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{
+			config.LabelManagedBy: config.ManagedByKorb,
+			config.LabelRole:      config.RoleMover,
+		}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs.Items {
+		for _, vol := range getPVCs(job.Spec.Template.Spec.Volumes) {
+			migrating[vol.PersistentVolumeClaim.ClaimName] = true
+		}
+	}
+	for _, pvc := range pvcs {
+		if _, ok := pvc.Labels[config.LabelMigrationID]; ok {
+			migrating[pvc.Name] = true
+		}
+		if pvc.Labels[config.LabelManagedBy] != config.ManagedByKorb || pvc.Labels[config.LabelRole] != config.RoleTempPVC {
+			continue
+		}
+		// Temporary PVCs are always created in the namespace of their source
+		if source, ok := pvc.Annotations[config.AnnotationSourcePVC]; ok {
+			_, name, _ := strings.Cut(source, "/")
+			migrating[name] = true
+		}
+	}
+	return migrating, nil
+}
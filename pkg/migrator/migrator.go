@@ -2,15 +2,21 @@ package migrator
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"beryju.org/korb/v2/pkg/archive"
+	"beryju.org/korb/v2/pkg/kube"
+	"beryju.org/korb/v2/pkg/mover"
 	"beryju.org/korb/v2/pkg/strategies"
 
+	v1 "k8s.io/api/core/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type Migrator struct {
@@ -24,49 +30,69 @@ type Migrator struct {
 	DestPVCAccessModes  []string
 
 	Force                  bool
+	SkipUsageCheck         bool
 	WaitForTempDestPVCBind bool
 	TolerateAllNodes       bool
+	RetainSourcePV         bool
+	OfflineResize          bool
+	ArchivePath            string
+	Encryption             *archive.Key
+	SplitSize              int64
+	ImportMode             string
+	RsyncOptions           mover.RsyncOptions
+	Filter                 mover.Filter
+	Ownership              mover.Ownership
+	SourceSubPath          string
+	DestSubPath            string
+	Mappings               []mover.Mapping
 	Timeout                *time.Duration
 	CopyTimeout            *time.Duration
+	// ExcludedStrategies are never considered compatible, for callers which can't run them
+	ExcludedStrategies []string
+
+	// MigrationID identifies all objects created and retained by this migration
+	MigrationID string
+	// OnStage is called whenever the selected strategy starts a new stage
+	OnStage func(stage int, description string)
 
 	kConfig *rest.Config
 	kClient *kubernetes.Clientset
 
+	// Only set when the destination is in a different cluster
+	destKConfig *rest.Config
+	destKClient *kubernetes.Clientset
+
 	log      *log.Entry
 	strategy string
 	ctx      context.Context
+
+	sourcePVC  *v1.PersistentVolumeClaim
+	sourcePods []v1.Pod
+	selected   strategies.Strategy
+	// ownerCache stores the owners resolved for each kind/name, only set while listing PVCs
+	ownerCache map[string][]interface{}
 }
 
-func New(ctx context.Context, kubeconfigPath string, strategy string, tolerateAllNode bool) *Migrator {
+func New(ctx context.Context, kubeOpts kube.Options, strategy string, tolerateAllNode bool) *Migrator {
 	m := &Migrator{
 		log:              log.WithField("component", "migrator"),
 		ctx:              ctx,
 		TolerateAllNodes: tolerateAllNode,
-		strategy:         strategy,
+		RetainSourcePV:   true,
+		// Disabled automatically for storage classes which bind on first consumer
+		WaitForTempDestPVCBind: true,
+		MigrationID:            utilrand.String(8),
+		strategy:               strategy,
 	}
-	if kubeconfigPath != "" {
-		m.log.WithField("kubeconfig", kubeconfigPath).Debug("Created client from kubeconfig")
-		cc := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
-			&clientcmd.ConfigOverrides{})
-
-		// use the current context in kubeconfig
-		config, err := cc.ClientConfig()
-		if err != nil {
-			m.log.WithError(err).Panic("Failed to get client config")
-		}
-		m.kConfig = config
-		ns, _, err := cc.Namespace()
-		if err != nil {
-			m.log.WithError(err).Panic("Failed to get current namespace")
-		} else {
-			m.log.WithField("namespace", ns).Debug("Got current namespace")
-			m.SourceNamespace = ns
-			m.DestNamespace = ns
-		}
-	} else {
-		m.log.Panic("Kubeconfig cannot be empty")
+	m.log.WithField("kubeconfig", kubeOpts.KubeConfig).Debug("Created client from kubeconfig")
+	config, ns, err := kube.NewConfig(kubeOpts)
+	if err != nil {
+		m.log.WithError(err).Panic("Failed to get client config")
 	}
+	m.kConfig = config
+	m.log.WithField("namespace", ns).Debug("Got current namespace")
+	m.SourceNamespace = ns
+	m.DestNamespace = ns
 
 	// create the clientset
 	clientset, err := kubernetes.NewForConfig(m.kConfig)
@@ -77,14 +103,42 @@ func New(ctx context.Context, kubeconfigPath string, strategy string, tolerateAl
 	return m
 }
 
-func (m *Migrator) Run() {
-	sourcePVC, compatibleStrategies := m.Validate()
+// SetDestinationCluster configures a separate cluster in which the destination PVC is created.
+// The destination namespace isn't changed, so it stays the namespace of the source PVC unless set explicitly.
+func (m *Migrator) SetDestinationCluster(kubeOpts kube.Options) error {
+	config, ns, err := kube.NewConfig(kubeOpts)
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	m.log.WithField("kubeconfig", kubeOpts.KubeConfig).WithField("context", kubeOpts.Context).WithField("namespace", ns).Debug("Created destination client")
+	m.destKConfig = config
+	m.destKClient = client
+	return nil
+}
+
+// destClient returns the client for the cluster the destination PVC is created in
+func (m *Migrator) destClient() *kubernetes.Clientset {
+	if m.destKClient != nil {
+		return m.destKClient
+	}
+	return m.kClient
+}
+
+// Prepare validates the migration and selects the strategy to use, without changing
+// any data in the cluster. Run calls Prepare if it hasn't been called before.
+func (m *Migrator) Prepare() error {
+	sourcePVC, compatibleStrategies, err := m.Validate()
+	if err != nil {
+		return err
+	}
 	m.log.Debug("Compatible Strategies:")
 	for _, compatibleStrategy := range compatibleStrategies {
 		m.log.WithField("identifier", compatibleStrategy.Identifier()).Debug(compatibleStrategy.Description())
 	}
-	destTemplate := m.GetDestinationPVCTemplate(sourcePVC)
-	destTemplate.Name = m.DestPVCName
 
 	var selected strategies.Strategy
 
@@ -101,11 +155,95 @@ func (m *Migrator) Run() {
 		}
 	}
 	if selected == nil {
-		m.log.Error("No (compatible) strategy selected.")
-		return
+		return errors.New("no (compatible) strategy selected")
 	}
-	err := selected.Do(sourcePVC, destTemplate, m.WaitForTempDestPVCBind)
+	err = m.validateSourceConsumers(selected)
 	if err != nil {
-		m.log.WithError(err).Warning("Failed to migrate")
+		return err
+	}
+	if skipper, ok := selected.(strategies.CapacityCheckSkipper); !m.SkipUsageCheck && (!ok || !skipper.SkipCapacityCheck()) {
+		err = m.validateSourceUsage(sourcePVC, m.GetDestinationPVCTemplate(sourcePVC))
+		if err != nil {
+			return err
+		}
+	} else if m.SourceSubPath != "" {
+		// The pre-flight mover checks that the subpath exists, before anything is copied
+		_, err = m.measureSourceUsage(sourcePVC)
+		if err != nil {
+			return err
+		}
+	}
+	m.sourcePVC = sourcePVC
+	m.selected = selected
+	return nil
+}
+
+func (m *Migrator) Run() error {
+	if m.selected == nil {
+		err := m.Prepare()
+		if err != nil {
+			return err
+		}
+	}
+	destTemplate := m.GetDestinationPVCTemplate(m.sourcePVC)
+	destTemplate.Name = m.DestPVCName
+	err := m.selected.Do(m.sourcePVC, destTemplate, m.WaitForTempDestPVCBind)
+	if m.ctx.Err() != nil {
+		// Strategies clean up after themselves and report what they couldn't remove
+		return fmt.Errorf("migration interrupted: %w", errors.Join(m.ctx.Err(), err))
+	}
+	return err
+}
+
+func (m *Migrator) baseStrategy() strategies.BaseStrategy {
+	return strategies.NewBaseStrategy(&strategies.BaseStrategyOpts{
+		Config:           m.kConfig,
+		Client:           m.kClient,
+		DestConfig:       m.destKConfig,
+		DestClient:       m.destKClient,
+		TolerateAllNodes: m.TolerateAllNodes,
+		RetainSourcePV:   m.RetainSourcePV,
+		OfflineResize:    m.OfflineResize,
+		ArchivePath:      m.ArchivePath,
+		Encryption:       m.Encryption,
+		SplitSize:        m.SplitSize,
+		ImportMode:       m.ImportMode,
+		RsyncOptions:     m.RsyncOptions,
+		Filter:           m.Filter,
+		Ownership:        m.Ownership,
+		SourceSubPath:    m.SourceSubPath,
+		DestSubPath:      m.DestSubPath,
+		Mappings:         m.Mappings,
+		MigrationID:      m.MigrationID,
+		Timeout:          m.Timeout,
+		CopyTimeout:      m.CopyTimeout,
+		OnStage:          m.OnStage,
+		Ctx:              m.ctx,
+	})
+}
+
+// Selected returns the strategy selected by Prepare, or nil if Prepare wasn't called yet.
+func (m *Migrator) Selected() strategies.Strategy {
+	return m.selected
+}
+
+// Resume finishes a migration which was interrupted after the source PVC was deleted, so it
+// can't be validated and run again. MigrationID must be set to the ID of the interrupted migration,
+// and the strategy must be the one the interrupted migration used.
+func (m *Migrator) Resume() error {
+	if m.DestPVCName == "" {
+		m.DestPVCName = m.SourcePVCName
+	}
+	for _, s := range strategies.StrategyInstances(m.baseStrategy()) {
+		if s.Identifier() != m.strategy {
+			continue
+		}
+		resumable, ok := s.(strategies.Resumable)
+		if !ok {
+			return fmt.Errorf("strategy %s can't resume interrupted migrations", s.Identifier())
+		}
+		m.log.WithField("migration-id", m.MigrationID).WithField("identifier", s.Identifier()).Info("Resuming migration")
+		return resumable.Resume(m.DestNamespace, m.DestPVCName)
 	}
+	return fmt.Errorf("unknown strategy '%s'", m.strategy)
 }
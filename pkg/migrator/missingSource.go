@@ -0,0 +1,72 @@
+package migrator
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"beryju.org/korb/v2/pkg/archive"
+	"beryju.org/korb/v2/pkg/strategies"
+)
+
+// createsMissingSource checks whether the strategy selected by the user creates the source PVC
+// if it doesn't exist, like the import strategy.
+func (m *Migrator) createsMissingSource() bool {
+	for _, strategy := range strategies.StrategyInstances(m.baseStrategy()) {
+		if strategy.Identifier() != m.strategy {
+			continue
+		}
+		sc, ok := strategy.(strategies.SourceCreator)
+		return ok && sc.CreatesMissingSource()
+	}
+	return false
+}
+
+// missingSourcePVC returns a placeholder for a source PVC which doesn't exist yet, and will be created
+// by the selected strategy. The placeholder has no UID. Its size, storage class and access modes are
+// taken from the destination options, or from the manifest of the archive.
+func (m *Migrator) missingSourcePVC() (*v1.PersistentVolumeClaim, error) {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.SourcePVCName,
+			Namespace: m.SourceNamespace,
+		},
+	}
+	var size resource.Quantity
+	base := m.baseStrategy()
+	path := base.ArchivePath(m.SourcePVCName)
+	if manifest, err := archive.ReadManifest(path); err == nil && manifest.Source != nil {
+		source := manifest.Source
+		m.log.WithField("archive", path).Debug("Using PVC from archive manifest")
+		if source.StorageClass != "" {
+			pvc.Spec.StorageClassName = &source.StorageClass
+		}
+		if source.Size != "" {
+			size, err = resource.ParseQuantity(source.Size)
+			if err != nil {
+				return nil, fmt.Errorf("invalid size in manifest: %w", err)
+			}
+		}
+		for _, mode := range source.AccessModes {
+			pvc.Spec.AccessModes = append(pvc.Spec.AccessModes, v1.PersistentVolumeAccessMode(mode))
+		}
+	}
+	if m.DestPVCStorageClass != "" {
+		pvc.Spec.StorageClassName = &m.DestPVCStorageClass
+	}
+	size = m.GetDestPVCSize(size)
+	if size.IsZero() {
+		return nil, fmt.Errorf("source PVC %s does not exist, and its size is unknown: set the size of the new PVC", m.SourcePVCName)
+	}
+	pvc.Spec.Resources.Requests = v1.ResourceList{
+		v1.ResourceStorage: size,
+	}
+	pvc.Spec.AccessModes = m.GetDestPVCAccessModes(pvc.Spec.AccessModes)
+	if len(pvc.Spec.AccessModes) == 0 {
+		pvc.Spec.AccessModes = []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}
+	}
+	m.log.WithField("size", size.String()).Info("Source PVC does not exist, it will be created")
+	return pvc, nil
+}
@@ -1,47 +1,122 @@
 package migrator
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"beryju.org/korb/v2/pkg/mover"
 	"beryju.org/korb/v2/pkg/strategies"
 )
 
-func (m *Migrator) Validate() (*v1.PersistentVolumeClaim, []strategies.Strategy) {
-	pvc := m.validateSourcePVC()
-	controllers, err := m.getPVCControllers(pvc)
+func (m *Migrator) Validate() (*v1.PersistentVolumeClaim, []strategies.Strategy, error) {
+	if err := m.Filter.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	if err := m.Ownership.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid ownership: %w", err)
+	}
+	for _, subPath := range []string{m.SourceSubPath, m.DestSubPath} {
+		if err := mover.ValidateSubPath(subPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(m.Mappings) > 0 {
+		if err := mover.ValidateMappings(m.Mappings); err != nil {
+			return nil, nil, fmt.Errorf("invalid mappings: %w", err)
+		}
+	}
+	pvc, err := m.validateSourcePVC()
+	if err != nil {
+		return nil, nil, err
+	}
+	storageClass, err := m.validateStorageClass(pvc)
 	if err != nil {
-		m.log.WithError(err).Panic("Failed to get controllers")
-	}
-	baseStrategy := strategies.NewBaseStrategy(&strategies.BaseStrategyOpts{
-		Config:           m.kConfig,
-		Client:           m.kClient,
-		TolerateAllNodes: m.TolerateAllNodes,
-		Timeout:          m.Timeout,
-		CopyTimeout:      m.CopyTimeout,
-		Ctx:              m.ctx,
-	})
-	allStrategies := strategies.StrategyInstances(baseStrategy)
+		return nil, nil, err
+	}
+	pods, err := m.getPVCPods(pvc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get pods: %w", err)
+	}
+	mappedPods, err := m.getMappedPVCPods(pvc)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.sourcePods = append(pods, mappedPods...)
+	controllers := m.getPodControllers(pods)
+	allStrategies := strategies.StrategyInstances(m.baseStrategy())
 	compatibleStrategies := make([]strategies.Strategy, 0)
+	destTemplate := m.GetDestinationPVCTemplate(pvc)
+	destTemplate.Name = m.DestPVCName
 	ctx := strategies.MigrationContext{
-		PVCControllers: controllers,
-		SourcePVC:      *pvc,
+		PVCControllers:   controllers,
+		PVCPods:          pods,
+		SourcePVC:        *pvc,
+		DestTemplate:     destTemplate,
+		DestStorageClass: storageClass,
 	}
 	for _, strategy := range allStrategies {
+		if slices.Contains(m.ExcludedStrategies, strategy.Identifier()) {
+			continue
+		}
 		err := strategy.CompatibleWithContext(ctx)
+		if cc, ok := strategy.(strategies.CrossClusterCapable); err == nil && m.destKClient != nil && (!ok || !cc.SupportsCrossCluster()) {
+			err = fmt.Errorf("strategy %s can't migrate into another cluster", strategy.Identifier())
+		}
+		if sc, ok := strategy.(strategies.SourceCreator); err == nil && pvc.UID == "" && (!ok || !sc.CreatesMissingSource()) {
+			err = fmt.Errorf("source PVC %s does not exist", pvc.Name)
+		}
+		if mc, ok := strategy.(strategies.MappingCapable); err == nil && len(m.Mappings) > 0 && (!ok || !mc.SupportsMappings()) {
+			err = fmt.Errorf("strategy %s can't copy mappings", strategy.Identifier())
+		}
 		if err == nil {
 			compatibleStrategies = append(compatibleStrategies, strategy)
 		} else {
 			m.log.WithError(err).Info("Strategy not compatible")
 		}
 	}
-	return pvc, compatibleStrategies
+	return pvc, compatibleStrategies, nil
 }
 
-func (m *Migrator) validateSourcePVC() *v1.PersistentVolumeClaim {
+// validateSourceConsumers checks that no pods are using the source PVC while it is being migrated,
+// unless the selected strategy is safe to use with live data.
+func (m *Migrator) validateSourceConsumers(selected strategies.Strategy) error {
+	if len(m.sourcePods) == 0 {
+		return nil
+	}
+	if safe, ok := selected.(strategies.LiveDataSafe); ok && safe.SafeForLiveData() {
+		m.log.WithField("pods", len(m.sourcePods)).Debug("Source PVC is in use, but strategy is safe for live data")
+		return nil
+	}
+	pods := make([]string, 0, len(m.sourcePods))
+	for _, pod := range m.sourcePods {
+		pods = append(pods, pod.Name)
+	}
+	controllers := make([]string, 0)
+	for _, controller := range m.getPodControllers(m.sourcePods) {
+		controllers = append(controllers, ControllerName(controller))
+	}
+	if m.Force {
+		m.log.WithField("pods", strings.Join(pods, ",")).WithField("controllers", strings.Join(controllers, ",")).Warning("Source PVC is used by pods, ignoring because force.")
+		return nil
+	}
+	return fmt.Errorf(
+		"source PVC is used by pod(s) %s (controllers: %s), stop them before migrating",
+		strings.Join(pods, ", "), strings.Join(controllers, ", "),
+	)
+}
+
+func (m *Migrator) validateSourcePVC() (*v1.PersistentVolumeClaim, error) {
 	pvc, err := m.kClient.CoreV1().PersistentVolumeClaims(m.SourceNamespace).Get(m.ctx, m.SourcePVCName, metav1.GetOptions{})
+	if errors.IsNotFound(err) && m.createsMissingSource() {
+		pvc, err = m.missingSourcePVC()
+	}
 	if err != nil {
-		m.log.WithError(err).Panic("Failed to get Source PVC")
+		return nil, fmt.Errorf("failed to get source PVC: %w", err)
 	}
 	m.log.WithField("uid", pvc.UID).WithField("name", pvc.Name).Debug("Got Source PVC")
 	destPVCTemplate := m.GetDestinationPVCTemplate(pvc)
@@ -52,12 +127,41 @@ func (m *Migrator) validateSourcePVC() *v1.PersistentVolumeClaim {
 		if m.Force {
 			l.Warning("Destination PVC is smaller than source, ignoring because force.")
 		} else {
-			l.Panic("Destination PVC is smaller than source.")
+			return nil, fmt.Errorf("destination PVC (%s) is smaller than source (%s)", destSize.String(), sourceSize.String())
 		}
 	}
 	if m.DestPVCName == "" {
 		m.log.Debug("No new Name given, using old name")
 		m.DestPVCName = pvc.Name
 	}
-	return pvc
+	return pvc, nil
+}
+
+// getMappedPVCPods returns the pods using any other PVC of the mappings. Pods using the destinations
+// are included, as the data is copied into existing destination PVCs.
+func (m *Migrator) getMappedPVCPods(sourcePVC *v1.PersistentVolumeClaim) ([]v1.Pod, error) {
+	pods := []v1.Pod{}
+	if len(m.Mappings) == 0 {
+		return pods, nil
+	}
+	names := append(mover.SourcePVCs(m.Mappings), mover.DestPVCs(m.Mappings)...)
+	for _, name := range names {
+		if name == sourcePVC.Name {
+			continue
+		}
+		pvc, err := m.kClient.CoreV1().PersistentVolumeClaims(m.SourceNamespace).Get(m.ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) && slices.Contains(mover.DestPVCs(m.Mappings), name) {
+			// Destination PVCs which don't exist yet are created by the strategy
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get mapped PVC %s: %w", name, err)
+		}
+		pvcPods, err := m.getPVCPods(pvc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pods: %w", err)
+		}
+		pods = append(pods, pvcPods...)
+	}
+	return pods, nil
 }
@@ -1,16 +1,17 @@
 package migrator
 
 import (
+	"fmt"
+
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 )
 
-func (m *Migrator) getPVCControllers(pvcToCheck *corev1.PersistentVolumeClaim) ([]interface{}, error) {
-	pods, err := m.getPVCPods(pvcToCheck)
-	if err != nil {
-		return nil, err
-	}
-
+// getPodControllers returns the top-level controllers of all pods, without duplicates.
+func (m *Migrator) getPodControllers(pods []corev1.Pod) []interface{} {
+	controllers := make([]interface{}, 0)
+	seen := map[string]struct{}{}
 	for _, pod := range pods {
 		for _, owner := range m.resolveOwner(pod.ObjectMeta, &appsv1.StatefulSet{}) {
 			switch owner.(type) {
@@ -19,8 +20,37 @@ func (m *Migrator) getPVCControllers(pvcToCheck *corev1.PersistentVolumeClaim) (
 			case *appsv1.StatefulSet:
 				m.log.Debug("Found statefulset")
 			}
+			obj, err := meta.Accessor(owner)
+			if err != nil || len(obj.GetOwnerReferences()) > 0 {
+				continue
+			}
+			name := ControllerName(owner)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			controllers = append(controllers, owner)
 		}
 	}
+	return controllers
+}
 
-	return nil, nil
+// ControllerName returns a human-readable kind/name for a controller returned by resolveOwner.
+func ControllerName(controller interface{}) string {
+	kind := "Unknown"
+	switch controller.(type) {
+	case *appsv1.Deployment:
+		kind = "Deployment"
+	case *appsv1.StatefulSet:
+		kind = "StatefulSet"
+	case *appsv1.DaemonSet:
+		kind = "DaemonSet"
+	case *appsv1.ReplicaSet:
+		kind = "ReplicaSet"
+	}
+	obj, err := meta.Accessor(controller)
+	if err != nil {
+		return kind
+	}
+	return fmt.Sprintf("%s/%s", kind, obj.GetName())
 }
@@ -3,6 +3,8 @@ package migrator
 import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"beryju.org/korb/v2/pkg/kube"
 )
 
 func (m *Migrator) getPVCPods(pvcToCheck *v1.PersistentVolumeClaim) ([]v1.Pod, error) {
@@ -14,13 +16,9 @@ func (m *Migrator) getPVCPods(pvcToCheck *v1.PersistentVolumeClaim) ([]v1.Pod, e
 	var pods []v1.Pod
 
 	for _, pod := range nsPods.Items {
-		pvcs := getPVCs(pod.Spec.Volumes)
-
-		for _, pvc := range pvcs {
-			if pvc.PersistentVolumeClaim.ClaimName == pvcToCheck.Name {
-				m.log.WithField("pod", pod.Name).Debug("Found pod which mounts source PVC")
-				pods = append(pods, pod)
-			}
+		if kube.UsesPVC(pod, pvcToCheck.Name) {
+			m.log.WithField("pod", pod.Name).Debug("Found pod which mounts source PVC")
+			pods = append(pods, pod)
 		}
 	}
 
@@ -0,0 +1,100 @@
+package migrator
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const annotationDefaultStorageClass = "storageclass.kubernetes.io/is-default-class"
+
+// blockProvisioners only support mounting a volume on a single node
+var blockProvisioners = []string{
+	"kubernetes.io/aws-ebs",
+	"ebs.csi.aws.com",
+	"kubernetes.io/gce-pd",
+	"pd.csi.storage.gke.io",
+	"kubernetes.io/azure-disk",
+	"disk.csi.azure.com",
+	"kubernetes.io/cinder",
+	"cinder.csi.openstack.org",
+	"rbd.csi.ceph.com",
+	"rancher.io/local-path",
+	"kubernetes.io/no-provisioner",
+	"dobs.csi.digitalocean.com",
+	"csi.hetzner.cloud",
+}
+
+// getDestStorageClass returns the storage class the destination PVC will use, or nil if the
+// destination doesn't use a storage class (for example for statically provisioned volumes).
+func (m *Migrator) getDestStorageClass(destTemplate *v1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	name := ""
+	if destTemplate.Spec.StorageClassName != nil {
+		name = *destTemplate.Spec.StorageClassName
+	} else {
+		// Without a storage class in the template, the destination PVC gets the default class
+		classes, err := m.destClient().StorageV1().StorageClasses().List(m.ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, sc := range classes.Items {
+			if sc.Annotations[annotationDefaultStorageClass] == "true" {
+				return &sc, nil
+			}
+		}
+		m.log.Debug("No default storage class found")
+		return nil, nil
+	}
+	if name == "" {
+		return nil, nil
+	}
+	sc, err := m.destClient().StorageV1().StorageClasses().Get(m.ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("storage class '%s' does not exist", name)
+	}
+	return sc, err
+}
+
+// validateStorageClass checks that the storage class of the destination exists, and whether
+// it supports the access modes of the destination. For storage classes which only bind volumes once
+// they're used by a pod, waiting for the temporary PVC to be bound is disabled.
+func (m *Migrator) validateStorageClass(sourcePVC *v1.PersistentVolumeClaim) (*storagev1.StorageClass, error) {
+	destTemplate := m.GetDestinationPVCTemplate(sourcePVC)
+	sc, err := m.getDestStorageClass(destTemplate)
+	if err != nil {
+		if m.Force {
+			m.log.WithError(err).Warning("Failed to get destination storage class, ignoring because force.")
+			return nil, nil
+		}
+		return nil, err
+	}
+	if sc == nil {
+		return nil, nil
+	}
+	l := m.log.WithField("storage-class", sc.Name).WithField("provisioner", sc.Provisioner)
+	l.Debug("Got destination storage class")
+
+	if sc.VolumeBindingMode != nil && *sc.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer && m.WaitForTempDestPVCBind {
+		l.Info("Storage class binds volumes on first consumer, not waiting for PVCs to be bound")
+		m.WaitForTempDestPVCBind = false
+	}
+
+	for _, mode := range destTemplate.Spec.AccessModes {
+		switch mode {
+		case v1.ReadWriteMany, v1.ReadOnlyMany:
+			if slices.Contains(blockProvisioners, sc.Provisioner) {
+				l.WithField("access-mode", mode).Warning("Provisioner most likely doesn't support this access mode, the destination PVC might not be bound")
+			}
+		case v1.ReadWriteOncePod:
+			if strings.HasPrefix(sc.Provisioner, "kubernetes.io/") {
+				l.WithField("access-mode", mode).Warning("Access mode is only supported by CSI provisioners, the destination PVC might not be bound")
+			}
+		}
+	}
+	return sc, nil
+}
@@ -0,0 +1,103 @@
+package migrator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"beryju.org/korb/v2/pkg/mover"
+	"beryju.org/korb/v2/pkg/strategies"
+)
+
+// usageOverhead is added to the used bytes of the source to account for
+// differences in filesystem overhead between source and destination.
+const usageOverhead = 1.05
+
+// bytesPerInode is the default ratio used by mkfs.ext4, used to estimate how
+// many inodes the destination will have.
+const bytesPerInode = 16 * 1024
+
+type sourceUsage struct {
+	Bytes  int64
+	Inodes int64
+}
+
+// measureSourceUsage starts a mover on the source PVC and measures how many bytes and inodes are used.
+// If a source subpath is set, only the subpath is measured, and an error is returned if it doesn't exist.
+func (m *Migrator) measureSourceUsage(sourcePVC *v1.PersistentVolumeClaim) (*sourceUsage, error) {
+	job := mover.NewMoverJob(m.ctx, m.kClient, mover.MoverTypeSleep, m.TolerateAllNodes)
+	job.Namespace = sourcePVC.Namespace
+	job.SourceVolume = sourcePVC
+	// A job left behind by an interrupted run must not block new runs
+	job.GenerateName = fmt.Sprintf("korb-preflight-%s-", sourcePVC.UID)
+	job.MigrationID = m.MigrationID
+	defer job.Cleanup()
+
+	timeout := strategies.DefaultTimeout
+	if m.Timeout != nil {
+		timeout = *m.Timeout
+	}
+	pod := job.Start().WaitForRunning(timeout)
+	if pod == nil {
+		return nil, errors.New("pre-flight mover did not start")
+	}
+	out := bytes.NewBuffer([]byte{})
+	cmd := []string{
+		"sh",
+		"-c",
+		`if [ ! -d "$1" ]; then echo missing; exit 0; fi; du -sk "$1" | cut -f1 && find "$1" -xdev | wc -l`,
+		"sh", path.Join(mover.SourceMount, m.SourceSubPath),
+	}
+	err := job.Exec(*pod, m.kConfig, cmd, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Fields(out.String())
+	if len(lines) == 1 && lines[0] == "missing" {
+		return nil, fmt.Errorf("source subpath '%s' does not exist or is not a directory", m.SourceSubPath)
+	}
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("unexpected output from pre-flight mover: '%s'", out.String())
+	}
+	kBytes, err := strconv.ParseInt(lines[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	inodes, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceUsage{Bytes: kBytes * 1024, Inodes: inodes}, nil
+}
+
+// validateSourceUsage checks that the data on the source PVC fits into the destination.
+func (m *Migrator) validateSourceUsage(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim) error {
+	usage, err := m.measureSourceUsage(sourcePVC)
+	if err != nil {
+		return fmt.Errorf("failed to measure source usage: %w", err)
+	}
+	destSize := destTemplate.Spec.Resources.Requests.Storage()
+	required := int64(float64(usage.Bytes) * usageOverhead)
+	l := m.log.WithField("used", resource.NewQuantity(usage.Bytes, resource.BinarySI).String()).
+		WithField("inodes", usage.Inodes).
+		WithField("dest-size", destSize.String())
+	l.Info("Measured source usage")
+	if destSize.Value() < required {
+		return fmt.Errorf(
+			"data on source (%s, %s with overhead) does not fit into destination (%s)",
+			resource.NewQuantity(usage.Bytes, resource.BinarySI).String(),
+			resource.NewQuantity(required, resource.BinarySI).String(),
+			destSize.String(),
+		)
+	}
+	if usage.Inodes > destSize.Value()/bytesPerInode {
+		l.Warning("Source uses more inodes than a default ext4 filesystem of the destination size would have")
+	}
+	return nil
+}
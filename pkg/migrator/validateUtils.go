@@ -10,30 +10,62 @@ func (m *Migrator) resolveOwner(meta metav1.ObjectMeta, expectedType interface{}
 	m.log.WithField("meta", meta.Name).Debug("Walking owners")
 	owners := make([]interface{}, 0)
 	for _, owner := range meta.OwnerReferences {
+		key := owner.Kind + "/" + owner.Name
+		if cached, ok := m.ownerCache[key]; ok {
+			owners = append(owners, cached...)
+			continue
+		}
 		l := m.log.WithField("meta", meta.Name).WithField("owner", owner.Name).WithField("kind", owner.Kind)
 		var ownerInstance interface{}
 		var err error
 		var meta metav1.ObjectMeta
-		if owner.Kind == "ReplicaSet" {
+		switch owner.Kind {
+		case "ReplicaSet":
 			var rs *appsv1.ReplicaSet
 			rs, err = m.kClient.AppsV1().ReplicaSets(m.SourceNamespace).Get(m.ctx, owner.Name, metav1.GetOptions{})
-			ownerInstance = rs
-			meta = rs.ObjectMeta
-		} else if owner.Kind == "Deployment" {
+			if err == nil {
+				ownerInstance = rs
+				meta = rs.ObjectMeta
+			}
+		case "Deployment":
 			var deployment *appsv1.Deployment
 			deployment, err = m.kClient.AppsV1().Deployments(m.SourceNamespace).Get(m.ctx, owner.Name, metav1.GetOptions{})
-			ownerInstance = deployment
-			meta = deployment.ObjectMeta
+			if err == nil {
+				ownerInstance = deployment
+				meta = deployment.ObjectMeta
+			}
+		case "StatefulSet":
+			var sts *appsv1.StatefulSet
+			sts, err = m.kClient.AppsV1().StatefulSets(m.SourceNamespace).Get(m.ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				ownerInstance = sts
+				meta = sts.ObjectMeta
+			}
+		case "DaemonSet":
+			var ds *appsv1.DaemonSet
+			ds, err = m.kClient.AppsV1().DaemonSets(m.SourceNamespace).Get(m.ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				ownerInstance = ds
+				meta = ds.ObjectMeta
+			}
+		default:
+			l.Debug("Unsupported owner kind")
+			continue
 		}
+		resolved := []interface{}{}
 		if err != nil {
 			l.Warningf("Failed to get owning %s", owner.Kind)
-			continue
+		} else {
+			resolved = append(resolved, m.resolveOwner(meta, expectedType)...)
+			// if reflect.TypeOf(ownerInstance) == reflect.TypeOf(expectedType) {
+			// 	l.Debug("Found matching owner")
+			// }
+			resolved = append(resolved, ownerInstance)
+		}
+		if m.ownerCache != nil {
+			m.ownerCache[key] = resolved
 		}
-		owners = append(owners, m.resolveOwner(meta, expectedType)...)
-		// if reflect.TypeOf(ownerInstance) == reflect.TypeOf(expectedType) {
-		// 	l.Debug("Found matching owner")
-		// }
-		owners = append(owners, ownerInstance)
+		owners = append(owners, resolved...)
 	}
 	return owners
 }
@@ -0,0 +1,47 @@
+package migrator
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Verify checks that the destination PVC exists after a migration, and that it uses the requested
+// storage class, size and access modes.
+func (m *Migrator) Verify() error {
+	name := m.DestPVCName
+	if name == "" {
+		name = m.SourcePVCName
+	}
+	pvc, err := m.destClient().CoreV1().PersistentVolumeClaims(m.DestNamespace).Get(m.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get destination PVC: %w", err)
+	}
+	if pvc.DeletionTimestamp != nil {
+		return fmt.Errorf("destination PVC %s is being deleted", name)
+	}
+	if pvc.Status.Phase == v1.ClaimLost {
+		return fmt.Errorf("destination PVC %s lost its volume", name)
+	}
+	if m.DestPVCStorageClass != "" && (pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != m.DestPVCStorageClass) {
+		return fmt.Errorf("destination PVC %s does not use storage class %s", name, m.DestPVCStorageClass)
+	}
+	if m.DestPVCSize != "" {
+		size := m.GetDestPVCSize(*pvc.Spec.Resources.Requests.Storage())
+		if pvc.Spec.Resources.Requests.Storage().Cmp(size) < 0 {
+			return fmt.Errorf("destination PVC %s requests %s, expected %s", name, pvc.Spec.Resources.Requests.Storage().String(), size.String())
+		}
+	}
+	for _, mode := range m.GetDestPVCAccessModes(nil) {
+		found := false
+		for _, actual := range pvc.Spec.AccessModes {
+			found = found || actual == mode
+		}
+		if !found {
+			return fmt.Errorf("destination PVC %s does not have access mode %s", name, mode)
+		}
+	}
+	m.log.WithField("pvc", name).WithField("phase", pvc.Status.Phase).Debug("Verified destination PVC")
+	return nil
+}
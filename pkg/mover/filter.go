@@ -0,0 +1,228 @@
+package mover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	FilterIncluded = "included"
+	FilterExcluded = "excluded"
+	// FilterPartial is used for directories of which only some paths are copied
+	FilterPartial = "partial"
+)
+
+// Filter selects which paths are copied. Patterns use the syntax of path.Match, and are matched
+// against paths relative to the copied directory (the source subpath, or else the root of the volume),
+// such as "cache" or "logs/*.log". A pattern
+// matches a path and everything below it, "*" never matches "/".
+// If Include is empty, all paths which aren't excluded are copied.
+type Filter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (f Filter) Empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Validate checks that all patterns can be used by rsync and tar.
+func (f Filter) Validate() error {
+	errs := []error{}
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if err := validatePattern(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %q: %w", pattern, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validatePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("pattern must not be empty")
+	}
+	if strings.ContainsAny(pattern, "\x00\n\r") {
+		return errors.New("pattern must not contain line breaks")
+	}
+	if strings.HasPrefix(pattern, "/") || strings.HasSuffix(pattern, "/") {
+		return errors.New("pattern must be relative to the root of the volume, without a trailing slash")
+	}
+	if strings.Contains(pattern, "**") {
+		return errors.New("** is not supported, use one * per directory")
+	}
+	for _, c := range strings.Split(pattern, "/") {
+		if c == "" || c == "." || c == ".." {
+			return errors.New("pattern must not contain empty, . or .. components")
+		}
+	}
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// cleanPath turns paths as listed by tar or find ("./a/b/") into paths relative to the root ("a/b").
+func cleanPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	return p
+}
+
+// matchesPrefix checks whether the pattern matches p or one of its parent directories.
+func matchesPrefix(pattern string, p string) bool {
+	pc, c := strings.Split(pattern, "/"), strings.Split(p, "/")
+	if len(c) < len(pc) {
+		return false
+	}
+	ok, _ := path.Match(pattern, strings.Join(c[:len(pc)], "/"))
+	return ok
+}
+
+// leadsTo checks whether p is a parent directory of paths the pattern can match.
+func leadsTo(pattern string, p string) bool {
+	pc, c := strings.Split(pattern, "/"), strings.Split(p, "/")
+	if len(c) >= len(pc) {
+		return false
+	}
+	ok, _ := path.Match(strings.Join(pc[:len(c)], "/"), p)
+	return ok
+}
+
+// Match checks whether the path is copied. Parent directories of included paths are copied as well.
+func (f Filter) Match(p string) bool {
+	p = cleanPath(p)
+	if p == "" {
+		return true
+	}
+	for _, pattern := range f.Exclude {
+		if matchesPrefix(pattern, p) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if matchesPrefix(pattern, p) || leadsTo(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify returns whether a top-level path is included, excluded or partially copied.
+func (f Filter) Classify(top string) string {
+	if !f.Match(top) {
+		return FilterExcluded
+	}
+	full := len(f.Include) == 0
+	for _, pattern := range f.Include {
+		if matchesPrefix(pattern, cleanPath(top)) {
+			full = true
+		}
+	}
+	for _, pattern := range f.Exclude {
+		if leadsTo(pattern, cleanPath(top)) {
+			full = false
+		}
+	}
+	if full {
+		return FilterIncluded
+	}
+	return FilterPartial
+}
+
+// RsyncArgs returns the filter rules for rsync. Patterns are anchored at the root of the transfer,
+// and parent directories of included paths are included so rsync descends into them.
+func (f Filter) RsyncArgs() []string {
+	args := []string{}
+	for _, pattern := range f.Exclude {
+		args = append(args, "--exclude=/"+pattern)
+	}
+	if len(f.Include) == 0 {
+		return args
+	}
+	for _, pattern := range f.Include {
+		c := strings.Split(pattern, "/")
+		for n := 1; n < len(c); n++ {
+			args = append(args, "--include=/"+strings.Join(c[:n], "/")+"/")
+		}
+		args = append(args, "--include=/"+pattern, "--include=/"+pattern+"/**")
+	}
+	return append(args, "--exclude=*")
+}
+
+// depth returns the number of directory levels which must be listed to resolve the include patterns.
+func (f Filter) depth() int {
+	depth := 0
+	for _, pattern := range f.Include {
+		depth = max(depth, strings.Count(pattern, "/")+1)
+	}
+	return depth
+}
+
+// ListPaths lists the paths in dir up to depth levels, relative to dir.
+func (m *MoverJob) ListPaths(pod corev1.Pod, config *rest.Config, dir string, depth int) ([]string, error) {
+	out := &bytes.Buffer{}
+	cmd := []string{
+		"bash",
+		"-c",
+		`cd "$1" && find . -mindepth 1 -maxdepth "$2" -print0`,
+		"bash", dir, strconv.Itoa(depth),
+	}
+	err := m.Exec(pod, config, cmd, nil, out)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, p := range strings.Split(out.String(), "\x00") {
+		if p = cleanPath(p); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// TarCreate returns the command which writes a tar archive of dir to stdout, which only contains the
+// paths selected by the filter. flags are passed to tar, for example "czf". Include patterns are
+// resolved by listing dir in the pod. Patterns and paths are passed as arguments, not as part of the script.
+func (m *MoverJob) TarCreate(pod corev1.Pod, config *rest.Config, dir string, flags string, filter Filter) ([]string, error) {
+	args := []string{}
+	if len(filter.Exclude) > 0 {
+		args = append(args, "--anchored", "--no-wildcards-match-slash")
+		for _, pattern := range filter.Exclude {
+			args = append(args, "--exclude=./"+pattern)
+		}
+	}
+	if len(filter.Include) == 0 {
+		args = append(args, ".")
+	} else {
+		paths, err := m.ListPaths(pod, config, dir, filter.depth())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list paths: %w", err)
+		}
+		members := 0
+		for _, p := range paths {
+			for _, pattern := range filter.Include {
+				if len(strings.Split(pattern, "/")) == len(strings.Split(p, "/")) && matchesPrefix(pattern, p) {
+					args = append(args, "./"+p)
+					members++
+					break
+				}
+			}
+		}
+		if members == 0 {
+			return nil, errors.New("no paths match the include patterns")
+		}
+	}
+	return append([]string{
+		"bash",
+		"-c",
+		fmt.Sprintf(`cd "$1" && shift && tar %s - "$@"`, flags),
+		"bash", dir,
+	}, args...), nil
+}
@@ -0,0 +1,123 @@
+package mover
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFilterValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pattern string
+		valid   bool
+	}{
+		{name: "name", pattern: "cache", valid: true},
+		{name: "nested", pattern: "logs/*.log", valid: true},
+		{name: "wildcard directory", pattern: "*/tmp", valid: true},
+		{name: "empty", pattern: ""},
+		{name: "absolute", pattern: "/cache"},
+		{name: "trailing slash", pattern: "cache/"},
+		{name: "double star", pattern: "**/tmp"},
+		{name: "parent directory", pattern: "../cache"},
+		{name: "current directory", pattern: "./cache"},
+		{name: "empty component", pattern: "logs//app"},
+		{name: "line break", pattern: "cache\n--delete"},
+		{name: "invalid syntax", pattern: "cache["},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, f := range []Filter{{Include: []string{tc.pattern}}, {Exclude: []string{tc.pattern}}} {
+				err := f.Validate()
+				if tc.valid && err != nil {
+					t.Errorf("expected pattern to be valid: %v", err)
+				}
+				if !tc.valid && err == nil {
+					t.Error("expected pattern to be rejected")
+				}
+			}
+		})
+	}
+}
+
+func TestFilterClassify(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		filter Filter
+		paths  map[string]string
+	}{
+		{
+			name:   "empty",
+			filter: Filter{},
+			paths:  map[string]string{"data": FilterIncluded, "./cache/": FilterIncluded},
+		},
+		{
+			name:   "exclude",
+			filter: Filter{Exclude: []string{"cache", "*.tmp"}},
+			paths:  map[string]string{"data": FilterIncluded, "cache": FilterExcluded, "./cache/": FilterExcluded, "a.tmp": FilterExcluded},
+		},
+		{
+			name:   "exclude nested",
+			filter: Filter{Exclude: []string{"data/tmp", "logs/*/cache"}},
+			paths:  map[string]string{"data": FilterPartial, "logs": FilterPartial, "cache": FilterIncluded},
+		},
+		{
+			name:   "include",
+			filter: Filter{Include: []string{"data"}},
+			paths:  map[string]string{"data": FilterIncluded, "logs": FilterExcluded, "database": FilterExcluded},
+		},
+		{
+			name:   "include nested",
+			filter: Filter{Include: []string{"logs/*.log"}},
+			paths:  map[string]string{"logs": FilterPartial, "data": FilterExcluded},
+		},
+		{
+			name:   "include and exclude",
+			filter: Filter{Include: []string{"data", "logs"}, Exclude: []string{"data/tmp", "logs"}},
+			paths:  map[string]string{"data": FilterPartial, "logs": FilterExcluded, "cache": FilterExcluded},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for p, want := range tc.paths {
+				if got := tc.filter.Classify(p); got != want {
+					t.Errorf("%s is %s, expected %s", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterRsyncArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "empty", filter: Filter{}, want: []string{}},
+		{name: "exclude", filter: Filter{Exclude: []string{"cache", "*/tmp"}}, want: []string{"--exclude=/cache", "--exclude=/*/tmp"}},
+		{
+			name:   "include",
+			filter: Filter{Include: []string{"data"}},
+			want:   []string{"--include=/data", "--include=/data/**", "--exclude=*"},
+		},
+		{
+			name:   "include nested",
+			filter: Filter{Include: []string{"logs/app/*.log"}},
+			want: []string{
+				"--include=/logs/", "--include=/logs/app/",
+				"--include=/logs/app/*.log", "--include=/logs/app/*.log/**",
+				"--exclude=*",
+			},
+		},
+		{
+			// Excludes come first, so they take precedence over includes
+			name:   "include and exclude",
+			filter: Filter{Include: []string{"data"}, Exclude: []string{"data/tmp"}},
+			want:   []string{"--exclude=/data/tmp", "--include=/data", "--include=/data/**", "--exclude=*"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.RsyncArgs(); !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package mover
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Location is a directory of a PVC, the root of the PVC if SubPath is empty.
+type Location struct {
+	PVC     string `json:"pvc"`
+	SubPath string `json:"subPath,omitempty"`
+}
+
+func (l Location) String() string {
+	if l.SubPath == "" {
+		return l.PVC
+	}
+	return l.PVC + ":" + l.SubPath
+}
+
+// Mapping copies a directory of one PVC into a directory of another PVC.
+type Mapping struct {
+	Source Location `json:"source"`
+	Dest   Location `json:"dest"`
+}
+
+func (m Mapping) String() string {
+	return m.Source.String() + "=" + m.Dest.String()
+}
+
+// ParseMapping parses a mapping in the form pvc[:subdir]=pvc[:subdir].
+func ParseMapping(raw string) (Mapping, error) {
+	source, dest, ok := strings.Cut(raw, "=")
+	if !ok {
+		return Mapping{}, fmt.Errorf("mapping '%s' must be in the form pvc[:subdir]=pvc[:subdir]", raw)
+	}
+	m := Mapping{
+		Source: parseLocation(source),
+		Dest:   parseLocation(dest),
+	}
+	return m, m.Validate()
+}
+
+func parseLocation(raw string) Location {
+	pvc, subPath, _ := strings.Cut(raw, ":")
+	return Location{PVC: pvc, SubPath: strings.Trim(subPath, "/")}
+}
+
+func (l Location) validate() error {
+	if errs := validation.IsDNS1123Subdomain(l.PVC); len(errs) > 0 {
+		return fmt.Errorf("invalid PVC name '%s': %s", l.PVC, strings.Join(errs, ", "))
+	}
+	return ValidateSubPath(l.SubPath)
+}
+
+func (m Mapping) Validate() error {
+	return errors.Join(m.Source.validate(), m.Dest.validate())
+}
+
+// ValidateMappings checks all mappings, and that no PVC is used as both source and destination.
+func ValidateMappings(mappings []Mapping) error {
+	if len(mappings) == 0 {
+		return errors.New("no mappings given")
+	}
+	errs := []error{}
+	sources := map[string]struct{}{}
+	for _, m := range mappings {
+		errs = append(errs, m.Validate())
+		sources[m.Source.PVC] = struct{}{}
+	}
+	for _, dest := range DestPVCs(mappings) {
+		if _, ok := sources[dest]; ok {
+			errs = append(errs, fmt.Errorf("PVC '%s' can't be used as source and destination", dest))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SourcePVCs returns the names of all source PVCs of the mappings, in order.
+func SourcePVCs(mappings []Mapping) []string {
+	return uniquePVCs(mappings, func(m Mapping) Location { return m.Source })
+}
+
+// DestPVCs returns the names of all destination PVCs of the mappings, in order.
+func DestPVCs(mappings []Mapping) []string {
+	return uniquePVCs(mappings, func(m Mapping) Location { return m.Dest })
+}
+
+func uniquePVCs(mappings []Mapping, get func(Mapping) Location) []string {
+	names := []string{}
+	for _, m := range mappings {
+		name := get(m).PVC
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mappingVolumes mounts all PVCs of the mappings, sources at SourcesMount/<pvc> and
+// destinations at DestsMount/<pvc>.
+func (m *MoverJob) mappingVolumes() ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{}
+	mounts := []corev1.VolumeMount{}
+	add := func(prefix string, mountPath string, names []string) {
+		for idx, name := range names {
+			volume := fmt.Sprintf("%s-%d", prefix, idx)
+			volumes = append(volumes, corev1.Volume{
+				Name: volume,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: name,
+					},
+				},
+			})
+			mounts = append(mounts, corev1.VolumeMount{
+				Name:      volume,
+				MountPath: path.Join(mountPath, name),
+			})
+		}
+	}
+	add("source", SourcesMount, SourcePVCs(m.Mappings))
+	add("dest", DestsMount, DestPVCs(m.Mappings))
+	return volumes, mounts
+}
+
+// mappingArgs returns the source and destination directory of each mapping, as pairs of arguments.
+func (m *MoverJob) mappingArgs() []string {
+	args := []string{}
+	for _, mapping := range m.Mappings {
+		args = append(args,
+			path.Join(SourcesMount, mapping.Source.PVC, mapping.Source.SubPath),
+			path.Join(DestsMount, mapping.Dest.PVC, mapping.Dest.SubPath),
+		)
+	}
+	return args
+}
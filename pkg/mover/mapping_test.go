@@ -0,0 +1,81 @@
+package mover
+
+import (
+	"testing"
+)
+
+func TestParseMapping(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		raw  string
+		want Mapping
+		err  bool
+	}{
+		{name: "whole PVCs", raw: "old=new", want: Mapping{Source: Location{PVC: "old"}, Dest: Location{PVC: "new"}}},
+		{
+			name: "subpaths",
+			raw:  "old:data=new:app/data",
+			want: Mapping{Source: Location{PVC: "old", SubPath: "data"}, Dest: Location{PVC: "new", SubPath: "app/data"}},
+		},
+		{
+			name: "slashes are trimmed",
+			raw:  "old:/data/=new:app/",
+			want: Mapping{Source: Location{PVC: "old", SubPath: "data"}, Dest: Location{PVC: "new", SubPath: "app"}},
+		},
+		{name: "empty subpath", raw: "old:=new", want: Mapping{Source: Location{PVC: "old"}, Dest: Location{PVC: "new"}}},
+		{name: "missing destination", raw: "old", err: true},
+		{name: "empty source", raw: "=new", err: true},
+		{name: "empty destination", raw: "old=", err: true},
+		{name: "invalid PVC name", raw: "Old_PVC=new", err: true},
+		{name: "parent directory", raw: "old:../data=new", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := ParseMapping(tc.raw)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got %s", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m != tc.want {
+				t.Errorf("got %s, expected %s", m, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateMappings(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mappings []string
+		valid    bool
+	}{
+		{name: "merge", mappings: []string{"a=c:a", "b=c:b"}, valid: true},
+		{name: "split", mappings: []string{"a:x=b", "a:y=c"}, valid: true},
+		{name: "same destination twice", mappings: []string{"a:x=b:data", "a:y=b:data"}, valid: true},
+		{name: "none"},
+		{name: "destination is a source", mappings: []string{"a=b", "b=c"}},
+		{name: "copy into itself", mappings: []string{"a:x=a:y"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mappings := []Mapping{}
+			for _, raw := range tc.mappings {
+				m, err := ParseMapping(raw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mappings = append(mappings, m)
+			}
+			err := ValidateMappings(mappings)
+			if tc.valid && err != nil {
+				t.Errorf("expected mappings to be valid: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("expected mappings to be rejected")
+			}
+		})
+	}
+}
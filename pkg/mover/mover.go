@@ -2,9 +2,12 @@ package mover
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
-	"errors"
+	"slices"
+	"strings"
 
 	"github.com/goware/prefixer"
 	log "github.com/sirupsen/logrus"
@@ -13,6 +16,7 @@ import (
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -26,21 +30,41 @@ type MoverType string
 const (
 	MoverTypeSync  MoverType = "sync"
 	MoverTypeSleep MoverType = "sleep"
+	// MoverTypeSyncMap copies the Mappings of the mover, with all PVCs mounted in one pod
+	MoverTypeSyncMap MoverType = "sync-map"
 )
 
 const (
 	SourceMount = "/source"
 	DestMount   = "/dest"
+	// SourcesMount and DestsMount contain a directory for each PVC of a sync-map mover
+	SourcesMount = "/sources"
+	DestsMount   = "/dests"
 )
 
 type MoverJob struct {
-	Name         string
+	Name string
+	// GenerateName is used instead of Name when set, so the API server generates a unique name for
+	// the job. Name is set to the generated name once the job is created.
+	GenerateName string
 	Namespace    string
+	MigrationID  string
 	SourceVolume *corev1.PersistentVolumeClaim
 	DestVolume   *corev1.PersistentVolumeClaim
+	// SourceSubPath and DestSubPath mount a directory of the volume instead of its root
+	SourceSubPath string
+	DestSubPath   string
+	// Mappings are copied by sync-map movers, which don't use SourceVolume and DestVolume
+	Mappings []Mapping
+	// RsyncOptions, Filter and Ownership are only used by sync movers
+	RsyncOptions RsyncOptions
+	Filter       Filter
+	Ownership    Ownership
 
 	kJob    *batchv1.Job
 	kClient *kubernetes.Clientset
+	// startErr is set if the job couldn't be created, and returned when waiting for it
+	startErr error
 
 	mode             MoverType
 	log              *log.Entry
@@ -48,6 +72,17 @@ type MoverJob struct {
 	ctx              context.Context
 }
 
+// ValidateSubPath checks that a subpath can be mounted, it must be relative to the root of the volume.
+func ValidateSubPath(subPath string) error {
+	if subPath == "" {
+		return nil
+	}
+	if strings.HasPrefix(subPath, "/") || slices.Contains(strings.Split(subPath, "/"), "..") {
+		return fmt.Errorf("subpath '%s' must be relative to the root of the volume, without ..", subPath)
+	}
+	return nil
+}
+
 func NewMoverJob(ctx context.Context, client *kubernetes.Clientset, mode MoverType, tolerateAllNodes bool) *MoverJob {
 	return &MoverJob{
 		kClient:          client,
@@ -59,24 +94,40 @@ func NewMoverJob(ctx context.Context, client *kubernetes.Clientset, mode MoverTy
 }
 
 func (m *MoverJob) Start() *MoverJob {
-	volumes := []corev1.Volume{
-		{
-			Name: "source",
-			VolumeSource: corev1.VolumeSource{
-				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-					ClaimName: m.SourceVolume.Name,
-					ReadOnly:  false,
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	args := []string{string(m.mode)}
+	if m.mode == MoverTypeSyncMap {
+		volumes, mounts = m.mappingVolumes()
+		args = append(args, m.RsyncOptions.Args()...)
+		args = append(args, m.Ownership.RsyncArgs(m.RsyncOptions)...)
+		args = append(args, m.Filter.RsyncArgs()...)
+		args = append(args, "--")
+		args = append(args, m.mappingArgs()...)
+	} else {
+		volumes = []corev1.Volume{
+			{
+				Name: "source",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: m.SourceVolume.Name,
+						ReadOnly:  false,
+					},
 				},
 			},
-		},
-	}
-	mounts := []corev1.VolumeMount{
-		{
-			Name:      "source",
-			MountPath: SourceMount,
-		},
+		}
+		mounts = []corev1.VolumeMount{
+			{
+				Name:      "source",
+				MountPath: SourceMount,
+				SubPath:   m.SourceSubPath,
+			},
+		}
 	}
 	if m.mode == MoverTypeSync {
+		args = append(args, m.RsyncOptions.Args()...)
+		args = append(args, m.Ownership.RsyncArgs(m.RsyncOptions)...)
+		args = append(args, m.Filter.RsyncArgs()...)
 		volumes = append(volumes, corev1.Volume{
 			Name: "dest",
 			VolumeSource: corev1.VolumeSource{
@@ -89,17 +140,21 @@ func (m *MoverJob) Start() *MoverJob {
 		mounts = append(mounts, corev1.VolumeMount{
 			Name:      "dest",
 			MountPath: DestMount,
+			SubPath:   m.DestSubPath,
 		})
 	}
 
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name,
-			Namespace: m.Namespace,
+			Name:         m.Name,
+			GenerateName: m.GenerateName,
+			Namespace:    m.Namespace,
+			Labels:       config.Labels(m.MigrationID, config.RoleMover),
 		},
 		Spec: batchv1.JobSpec{
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
+					Labels: config.Labels(m.MigrationID, config.RoleMover),
 					Annotations: map[string]string{
 						"sidecar.istio.io/inject": "false",
 						"linkerd.io/inject":       "disabled",
@@ -113,7 +168,7 @@ func (m *MoverJob) Start() *MoverJob {
 							Name:            ContainerName,
 							Image:           config.ContainerImage,
 							ImagePullPolicy: corev1.PullAlways,
-							Args:            []string{string(m.mode)},
+							Args:            args,
 							VolumeMounts:    mounts,
 							TTY:             true,
 							Stdin:           true,
@@ -132,11 +187,17 @@ func (m *MoverJob) Start() *MoverJob {
 		}
 	}
 
+	if m.GenerateName != "" {
+		job.Name = ""
+	}
 	j, err := m.kClient.BatchV1().Jobs(m.Namespace).Create(m.ctx, job, metav1.CreateOptions{})
 	if err != nil {
-		panic(err)
+		m.log.WithError(err).WithField("name", m.Name).Warning("Failed to create mover job")
+		m.startErr = fmt.Errorf("failed to create mover job: %w", err)
+		return m
 	}
 	m.kJob = j
+	m.Name = j.Name
 	return m
 }
 
@@ -173,18 +234,32 @@ func (m *MoverJob) getDeleteOptions() metav1.DeleteOptions {
 	}
 }
 
+// Cleanup deletes the job and its pods. This also works when the context of the
+// mover has been cancelled, see config.CleanupContext.
 func (m *MoverJob) Cleanup() error {
-	err := m.kClient.BatchV1().Jobs(m.Namespace).Delete(m.ctx, m.Name, m.getDeleteOptions())
-	if err != nil {
-		m.log.WithError(err).WithField("name", m.Name).Debug("Failed to delete job")
-		return err
+	if m.kJob == nil {
+		// The job was never created
+		return nil
 	}
-	pods := m.getPods(m.ctx)
-	for _, pod := range pods {
-		err := m.kClient.CoreV1().Pods(m.Namespace).Delete(m.ctx, pod.Name, m.getDeleteOptions())
-		if err != nil {
-			m.log.WithError(err).WithField("name", pod.Name).Warning("failed to delete pod")
+	ctx, cancel := config.CleanupContext(m.ctx)
+	defer cancel()
+	err := m.kClient.BatchV1().Jobs(m.Namespace).Delete(ctx, m.Name, m.getDeleteOptions())
+	if err != nil && !apierrors.IsNotFound(err) {
+		m.log.WithError(err).WithField("job", m.Name).Warning("Failed to delete mover job, job and its pods were left behind")
+		return fmt.Errorf("failed to delete mover job %s/%s: %w", m.Namespace, m.Name, err)
+	}
+	m.log.WithField("name", m.Name).Debug("Deleted job")
+	errs := []error{}
+	leftover := []string{}
+	for _, pod := range m.getPods(ctx) {
+		err := m.kClient.CoreV1().Pods(m.Namespace).Delete(ctx, pod.Name, m.getDeleteOptions())
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete mover pod %s/%s: %w", m.Namespace, pod.Name, err))
+			leftover = append(leftover, pod.Name)
 		}
 	}
-	return nil
+	if len(leftover) > 0 {
+		m.log.WithError(errors.Join(errs...)).WithField("pods", leftover).Warning("Failed to delete mover pods, pods were left behind")
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,137 @@
+package mover
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	chownPattern = regexp.MustCompile(`^(\d+)?(:\d+)?$`)
+	// Modes which rsync --chmod and chmod -R both understand, without rsync's D and F prefixes
+	chmodPattern = regexp.MustCompile(`^([0-7]{3,4}|[ugoa]*[-+=][rwxXst]*)(,[ugoa]*[-+=][rwxXst]*)*$`)
+)
+
+// Ownership changes the owner and permissions of copied files, so the destination can be used by a
+// workload running as another user. User and group IDs are always numeric, names are never resolved.
+type Ownership struct {
+	// Chown sets the owner of all files, as uid:gid, uid or :gid
+	Chown string `json:"chown,omitempty"`
+	// Chmod changes the permissions of all files, as an octal mode or comma-separated symbolic modes
+	Chmod string `json:"chmod,omitempty"`
+	// UIDMap changes the owner of files owned by one uid to another, as old:new
+	UIDMap []string `json:"uidMap,omitempty"`
+}
+
+// Empty returns true if the ownership of files isn't changed.
+func (o Ownership) Empty() bool {
+	return o.Chown == "" && o.Chmod == "" && len(o.UIDMap) == 0
+}
+
+// Validate checks the options before they are passed to the mover.
+func (o Ownership) Validate() error {
+	errs := []error{}
+	if o.Chown != "" && (o.Chown == ":" || !chownPattern.MatchString(o.Chown)) {
+		errs = append(errs, fmt.Errorf("chown '%s' must be numeric, as uid:gid, uid or :gid", o.Chown))
+	}
+	if o.Chmod != "" && !chmodPattern.MatchString(o.Chmod) {
+		errs = append(errs, fmt.Errorf("chmod '%s' must be an octal mode or symbolic modes like u+rwX,g+rX", o.Chmod))
+	}
+	if len(o.UIDMap) > 0 && o.chownsUser() {
+		errs = append(errs, errors.New("uid map can't be combined with a chown which sets the uid"))
+	}
+	sources := map[string]struct{}{}
+	targets := map[string]struct{}{}
+	for _, m := range o.UIDMap {
+		from, to, ok := strings.Cut(m, ":")
+		if !ok || !isID(from) || !isID(to) {
+			errs = append(errs, fmt.Errorf("uid map '%s' must be numeric, as old:new", m))
+			continue
+		}
+		if _, ok := sources[from]; ok {
+			errs = append(errs, fmt.Errorf("uid %s is mapped more than once", from))
+		}
+		sources[from] = struct{}{}
+		targets[to] = struct{}{}
+	}
+	// Mappings are applied one after another by the import, so they must not be chained
+	for from := range sources {
+		if _, ok := targets[from]; ok {
+			errs = append(errs, fmt.Errorf("uid %s is mapped to and from, which isn't supported", from))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o Ownership) chownsUser() bool {
+	return o.Chown != "" && !strings.HasPrefix(o.Chown, ":")
+}
+
+func isID(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+// RsyncArgs returns the arguments for rsync. --numeric-ids is added so IDs aren't mapped by name,
+// unless the rsync options already add it.
+func (o Ownership) RsyncArgs(rsync RsyncOptions) []string {
+	if o.Empty() {
+		return []string{}
+	}
+	args := []string{}
+	if !rsync.NumericIDs {
+		args = append(args, "--numeric-ids")
+	}
+	if len(o.UIDMap) > 0 {
+		args = append(args, "--usermap="+strings.Join(o.UIDMap, ","))
+	}
+	if o.Chown != "" {
+		args = append(args, "--chown="+o.Chown)
+	}
+	if o.Chmod != "" {
+		args = append(args, "--chmod="+o.Chmod)
+	}
+	return args
+}
+
+// TarArgs returns the arguments for extracting a tar archive, which keeps numeric IDs
+// instead of mapping them by name when the ownership is changed.
+func (o Ownership) TarArgs() []string {
+	if o.Empty() {
+		return []string{}
+	}
+	return []string{"--numeric-owner"}
+}
+
+// ApplyOwnership changes the owner and permissions of everything in dir after it was extracted, in the
+// same order as rsync: uids are mapped first, then chown and chmod are applied. The options are passed
+// as arguments, not as part of the script.
+func (m *MoverJob) ApplyOwnership(pod corev1.Pod, config *rest.Config, dir string, ownership Ownership) error {
+	if ownership.Empty() {
+		return nil
+	}
+	script := `set -e
+cd "$1" && chown="$2" && chmod="$3" && shift 3
+for m in "$@"; do
+    find . -uid "${m%%:*}" -exec chown -h "${m#*:}" {} +
+done
+if [[ -n $chown ]]; then
+    chown -R -h "$chown" .
+fi
+if [[ -n $chmod ]]; then
+    chmod -R "$chmod" .
+fi`
+	cmd := append([]string{
+		"bash",
+		"-c",
+		script,
+		"bash", dir, ownership.Chown, ownership.Chmod,
+	}, ownership.UIDMap...)
+	return m.Exec(pod, config, cmd, nil, os.Stdout)
+}
@@ -0,0 +1,74 @@
+package mover
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOwnershipValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ownership Ownership
+		valid     bool
+	}{
+		{name: "empty", ownership: Ownership{}, valid: true},
+		{name: "chown uid and gid", ownership: Ownership{Chown: "1000:1000"}, valid: true},
+		{name: "chown uid", ownership: Ownership{Chown: "1000"}, valid: true},
+		{name: "chown gid", ownership: Ownership{Chown: ":1000"}, valid: true},
+		{name: "chown colon only", ownership: Ownership{Chown: ":"}},
+		{name: "chown names", ownership: Ownership{Chown: "app:app"}},
+		{name: "chown negative", ownership: Ownership{Chown: "-1"}},
+		{name: "chmod octal", ownership: Ownership{Chmod: "750"}, valid: true},
+		{name: "chmod octal with special bits", ownership: Ownership{Chmod: "2775"}, valid: true},
+		{name: "chmod symbolic", ownership: Ownership{Chmod: "u+rwX,g+rX,o-rwx"}, valid: true},
+		{name: "chmod invalid octal", ownership: Ownership{Chmod: "789"}},
+		{name: "chmod rsync prefix", ownership: Ownership{Chmod: "Du+rwx"}},
+		{name: "chmod shell", ownership: Ownership{Chmod: "750; rm -rf /"}},
+		{name: "uid map", ownership: Ownership{UIDMap: []string{"1000:2000", "1001:2001"}}, valid: true},
+		{name: "uid map with chown gid", ownership: Ownership{UIDMap: []string{"1000:2000"}, Chown: ":2000"}, valid: true},
+		{name: "uid map with chown uid", ownership: Ownership{UIDMap: []string{"1000:2000"}, Chown: "2000"}},
+		{name: "uid map names", ownership: Ownership{UIDMap: []string{"app:2000"}}},
+		{name: "uid map without target", ownership: Ownership{UIDMap: []string{"1000"}}},
+		{name: "uid mapped twice", ownership: Ownership{UIDMap: []string{"1000:2000", "1000:3000"}}},
+		{name: "uid mapped to and from", ownership: Ownership{UIDMap: []string{"1000:2000", "2000:3000"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.ownership.Validate()
+			if tc.valid && err != nil {
+				t.Errorf("expected ownership to be valid: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Error("expected ownership to be rejected")
+			}
+		})
+	}
+}
+
+func TestOwnershipRsyncArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ownership Ownership
+		rsync     RsyncOptions
+		want      []string
+	}{
+		{name: "empty", ownership: Ownership{}, want: []string{}},
+		{name: "empty with numeric ids", ownership: Ownership{}, rsync: RsyncOptions{NumericIDs: true}, want: []string{}},
+		{
+			name:      "all options",
+			ownership: Ownership{Chown: ":100", Chmod: "g+rX", UIDMap: []string{"1000:2000", "1001:2001"}},
+			want:      []string{"--numeric-ids", "--usermap=1000:2000,1001:2001", "--chown=:100", "--chmod=g+rX"},
+		},
+		{
+			name:      "numeric ids from rsync options",
+			ownership: Ownership{Chown: "1000:1000"},
+			rsync:     RsyncOptions{NumericIDs: true},
+			want:      []string{"--chown=1000:1000"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.ownership.RsyncArgs(tc.rsync); !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package mover
+
+// RsyncOptions are passed to rsync by sync movers, in addition to the default -aHA.
+// They are passed to the mover as separate arguments, and never interpreted by a shell.
+// To exclude paths, use a Filter, which behaves the same for every strategy.
+type RsyncOptions struct {
+	// Delete removes files from the destination which don't exist in the source
+	Delete bool `json:"delete,omitempty"`
+	// Sparse creates holes in the destination for sparse files
+	Sparse bool `json:"sparse,omitempty"`
+	// Xattrs copies extended attributes
+	Xattrs bool `json:"xattrs,omitempty"`
+	// NumericIDs keeps uids and gids instead of mapping them by user and group name
+	NumericIDs bool `json:"numericIDs,omitempty"`
+}
+
+// Empty returns true if no options are set.
+func (o RsyncOptions) Empty() bool {
+	return o == RsyncOptions{}
+}
+
+// Args returns the arguments for rsync.
+func (o RsyncOptions) Args() []string {
+	args := []string{}
+	if o.Delete {
+		args = append(args, "--delete")
+	}
+	if o.Sparse {
+		args = append(args, "--sparse")
+	}
+	if o.Xattrs {
+		args = append(args, "--xattrs")
+	}
+	if o.NumericIDs {
+		args = append(args, "--numeric-ids")
+	}
+	return args
+}
@@ -22,6 +22,9 @@ func (m *MoverJob) getPods(ctx context.Context) []v1.Pod {
 }
 
 func (m *MoverJob) WaitForRunning(timeout time.Duration) *v1.Pod {
+	if m.startErr != nil {
+		return nil
+	}
 	// First we wait for all pods to be running
 	var runningPod v1.Pod
 	err := wait.PollUntilContextTimeout(m.ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
@@ -45,6 +48,9 @@ func (m *MoverJob) WaitForRunning(timeout time.Duration) *v1.Pod {
 }
 
 func (m *MoverJob) Wait(startTimeout time.Duration, moveTimeout time.Duration) error {
+	if m.startErr != nil {
+		return m.startErr
+	}
 	pod := m.WaitForRunning(startTimeout)
 	if pod == nil {
 		return errors.New("pod not in correct state")
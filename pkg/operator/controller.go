@@ -0,0 +1,313 @@
+package operator
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/kube"
+	"beryju.org/korb/v2/pkg/plan"
+)
+
+// unsupportedStrategies read or write archives on the local disk of korb, which the operator
+// has no defined place for.
+var unsupportedStrategies = []string{"export", "import"}
+
+// Controller reconciles PVCMigration objects by running them with the same migrator and
+// strategies as the CLI. The state of each migration is stored in its status, so that the
+// controller can pick up migrations which were running when it was restarted.
+type Controller struct {
+	// Namespace to watch, all namespaces if empty
+	Namespace string
+	// Interval in which all PVCMigrations are reconciled
+	Interval time.Duration
+	// MaxConcurrent is the maximum number of migrations running at the same time
+	MaxConcurrent int
+	// LeaderElection makes sure only one instance runs migrations, using a Lease in LeaseNamespace
+	LeaderElection bool
+	// LeaseNamespace defaults to the namespace of the kube context
+	LeaseNamespace string
+
+	kubeOpts kube.Options
+	client   dynamic.Interface
+	kClient  *kubernetes.Clientset
+
+	log *log.Entry
+	ctx context.Context
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func NewController(ctx context.Context, kubeOpts kube.Options) (*Controller, error) {
+	kConfig, ns, err := kube.NewConfig(kubeOpts)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(kConfig)
+	if err != nil {
+		return nil, err
+	}
+	kClient, err := kubernetes.NewForConfig(kConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Controller{
+		Interval:       30 * time.Second,
+		MaxConcurrent:  1,
+		LeaderElection: true,
+		LeaseNamespace: ns,
+		kubeOpts:       kubeOpts,
+		client:         client,
+		kClient:        kClient,
+		log:            log.WithField("component", "operator"),
+		ctx:            ctx,
+		running:        map[string]context.CancelFunc{},
+	}, nil
+}
+
+// Dynamic returns the dynamic client of the controller.
+func (c *Controller) Dynamic() dynamic.Interface {
+	return c.client
+}
+
+// Run reconciles all PVCMigrations every Interval until the context is cancelled. Once cancelled,
+// running migrations are interrupted, and Run returns after they cleaned up. With LeaderElection,
+// Run waits until this instance is the leader, and returns an error if the leadership is lost.
+func (c *Controller) Run() error {
+	c.log.WithField("namespace", c.Namespace).WithField("interval", c.Interval).Info("Starting operator")
+	if c.LeaderElection {
+		return c.runLeader()
+	}
+	c.run(c.ctx)
+	return nil
+}
+
+func (c *Controller) run(ctx context.Context) {
+	c.ctx = ctx
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		err := c.reconcileAll()
+		if err != nil {
+			c.log.WithError(err).Warning("Failed to reconcile")
+		}
+		select {
+		case <-c.ctx.Done():
+			c.log.Info("Stopping operator, waiting for running migrations to clean up")
+			c.wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Controller) reconcileAll() error {
+	list, err := c.client.Resource(PVCMigrationResource).Namespace(c.Namespace).List(c.ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	seen := map[string]struct{}{}
+	migs := []*PVCMigration{}
+	for _, u := range list.Items {
+		seen[key(u.GetNamespace(), u.GetName())] = struct{}{}
+		mig, err := fromUnstructured(&u)
+		if err != nil {
+			c.log.WithError(err).Warning("Skipping PVCMigration")
+			continue
+		}
+		migs = append(migs, mig)
+	}
+	// Interrupted migrations are recovered before new migrations are started
+	slices.SortStableFunc(migs, func(a, b *PVCMigration) int {
+		return cmp.Compare(phaseOrder(a.Status.Phase), phaseOrder(b.Status.Phase))
+	})
+	for _, mig := range migs {
+		c.reconcile(mig)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, cancel := range c.running {
+		if _, ok := seen[k]; !ok {
+			c.log.WithField("pvcmigration", k).Warning("PVCMigration was deleted, interrupting migration")
+			cancel()
+		}
+	}
+	return nil
+}
+
+func (c *Controller) reconcile(mig *PVCMigration) {
+	l := c.log.WithField("pvcmigration", key(mig.Namespace, mig.Name))
+	switch mig.Status.Phase {
+	case PhaseSucceeded, PhaseFailed:
+		return
+	case PhaseRunning:
+		if c.isRunning(mig) {
+			return
+		}
+		// The migration is marked as running, but not by this process, so the
+		// operator was restarted during the migration
+		if c.runningCount() >= c.MaxConcurrent {
+			l.Info("Found interrupted migration, waiting for other migrations to finish before recovering")
+			return
+		}
+		l.Warning("Found interrupted migration, recovering")
+		c.start(mig, true)
+		return
+	}
+
+	if err := c.validateSpec(mig); err != nil {
+		c.fail(mig, ConditionValidated, "InvalidSpec", err)
+		return
+	}
+	allowed, err := mig.Spec.Schedule.Allows(time.Now())
+	if err != nil {
+		c.fail(mig, ConditionValidated, "InvalidSchedule", err)
+		return
+	}
+	if !allowed {
+		c.setPending(mig, "Waiting for schedule window")
+		return
+	}
+	if c.runningCount() >= c.MaxConcurrent {
+		c.setPending(mig, "Waiting for other migrations to finish")
+		return
+	}
+	c.start(mig, false)
+}
+
+// validateSpec checks the spec like a plan file, and makes sure that only PVCs in the
+// namespace of the PVCMigration are migrated.
+func (c *Controller) validateSpec(mig *PVCMigration) error {
+	if ns := mig.Spec.Source.Namespace; ns != "" && ns != mig.Namespace {
+		return fmt.Errorf("source namespace %s must be the namespace of the PVCMigration", ns)
+	}
+	if slices.Contains(unsupportedStrategies, mig.Spec.Strategy) {
+		return fmt.Errorf("strategy %s is not supported by the operator, use korb backup and korb restore instead", mig.Spec.Strategy)
+	}
+	p := plan.Plan{Migrations: []plan.Migration{mig.Spec.Migration}}
+	p.SetDefaultNamespace(mig.Namespace)
+	return p.Validate()
+}
+
+func (c *Controller) setPending(mig *PVCMigration, message string) {
+	if mig.Status.Phase == PhasePending && mig.Status.Message == message {
+		return
+	}
+	_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+		s.Phase = PhasePending
+		s.Message = message
+	})
+}
+
+// fail marks the migration as failed, with condition set to False.
+func (c *Controller) fail(mig *PVCMigration, condition string, reason string, err error) {
+	c.log.WithField("pvcmigration", key(mig.Namespace, mig.Name)).WithError(err).Warning("Migration failed")
+	_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+		s.Phase = PhaseFailed
+		s.Message = err.Error()
+		s.CompletionTime = &metav1.Time{Time: time.Now()}
+		setCondition(s, condition, metav1.ConditionFalse, reason, err.Error())
+		setCondition(s, ConditionFailed, metav1.ConditionTrue, reason, err.Error())
+	})
+}
+
+func setCondition(s *PVCMigrationStatus, condition string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&s.Conditions, metav1.Condition{
+		Type:    condition,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// updateStatus re-reads the PVCMigration and applies mutate to its status. The status is also
+// written after the operator was interrupted, so the next instance knows the state of the migration.
+func (c *Controller) updateStatus(mig *PVCMigration, mutate func(s *PVCMigrationStatus)) error {
+	ctx, cancel := config.CleanupContext(c.ctx)
+	defer cancel()
+	res := c.client.Resource(PVCMigrationResource).Namespace(mig.Namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := res.Get(ctx, mig.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current, err := fromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		mutate(&current.Status)
+		current.Status.ObservedGeneration = current.Generation
+		status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&current.Status)
+		if err != nil {
+			return err
+		}
+		u.Object["status"] = status
+		_, err = res.UpdateStatus(ctx, u, metav1.UpdateOptions{})
+		if err == nil {
+			mig.Status = current.Status
+		}
+		return err
+	})
+	if err != nil {
+		c.log.WithField("pvcmigration", key(mig.Namespace, mig.Name)).WithError(err).Warning("Failed to update status")
+	}
+	return err
+}
+
+func (c *Controller) isRunning(mig *PVCMigration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.running[key(mig.Namespace, mig.Name)]
+	return ok
+}
+
+func (c *Controller) runningCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.running)
+}
+
+// start runs the migration in the background. If interrupted is true, the migration was
+// interrupted by a restart of the operator, and the objects it left behind are cleaned up first.
+func (c *Controller) start(mig *PVCMigration, interrupted bool) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	c.running[key(mig.Namespace, mig.Name)] = cancel
+	c.mu.Unlock()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.running, key(mig.Namespace, mig.Name))
+			c.mu.Unlock()
+			cancel()
+		}()
+		c.migrate(ctx, mig, interrupted)
+	}()
+}
+
+func phaseOrder(phase Phase) int {
+	if phase == PhaseRunning {
+		return 0
+	}
+	return 1
+}
+
+func key(namespace string, name string) string {
+	return namespace + "/" + name
+}
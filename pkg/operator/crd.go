@@ -0,0 +1,44 @@
+package operator
+
+import (
+	"context"
+	_ "embed"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// CRD is the manifest of the PVCMigration CustomResourceDefinition
+//
+//go:embed crd.yaml
+var CRD []byte
+
+var crdResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1",
+	Resource: "customresourcedefinitions",
+}
+
+// InstallCRD creates the PVCMigration CustomResourceDefinition, or updates it if it already exists.
+func InstallCRD(ctx context.Context, client dynamic.Interface) error {
+	crd := &unstructured.Unstructured{}
+	err := yaml.Unmarshal(CRD, &crd.Object)
+	if err != nil {
+		return err
+	}
+	existing, err := client.Resource(crdResource).Get(ctx, crd.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Resource(crdResource).Create(ctx, crd, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	crd.SetResourceVersion(existing.GetResourceVersion())
+	_, err = client.Resource(crdResource).Update(ctx, crd, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,100 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"beryju.org/korb/v2/pkg/config"
+)
+
+// LeaseName is the name of the Lease used for leader election between operator instances
+const LeaseName = "korb-operator"
+
+// runLeader waits until this instance holds the Lease, and then reconciles until the context is
+// cancelled or the Lease is lost. The Lease is only released once running migrations cleaned up,
+// so another instance never recovers a migration which is still being interrupted.
+func (c *Controller) runLeader() error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	id := fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      LeaseName,
+			Namespace: c.LeaseNamespace,
+			Labels: map[string]string{
+				config.LabelManagedBy: config.ManagedByKorb,
+			},
+		},
+		Client: c.kClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
+	}
+	l := c.log.WithField("lease", key(c.LeaseNamespace, LeaseName)).WithField("identity", id)
+
+	parent := c.ctx
+	electorCtx, stop := context.WithCancel(context.WithoutCancel(parent))
+	defer stop()
+	leading := make(chan context.Context, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				leading <- ctx
+			},
+			OnStoppedLeading: func() {
+				l.Info("Stopped leading")
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					l.WithField("leader", identity).Info("Another instance is leading, waiting")
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		elector.Run(electorCtx)
+	}()
+
+	l.Info("Waiting to become leader")
+	select {
+	case <-parent.Done():
+		stop()
+		<-done
+		return nil
+	case <-done:
+		return fmt.Errorf("leader election stopped")
+	case leaderCtx := <-leading:
+		l.Info("Became leader")
+		ctx, cancel := context.WithCancel(leaderCtx)
+		defer cancel()
+		unregister := context.AfterFunc(parent, cancel)
+		defer unregister()
+		c.run(ctx)
+		stop()
+		<-done
+		if parent.Err() == nil {
+			return fmt.Errorf("lost leadership")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,176 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"beryju.org/korb/v2/pkg/cleanup"
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/migrator"
+)
+
+// migrate runs a single migration and reports its progress in the status of mig.
+func (c *Controller) migrate(ctx context.Context, mig *PVCMigration, interrupted bool) {
+	l := c.log.WithField("pvcmigration", key(mig.Namespace, mig.Name))
+	spec := mig.Spec.Migration
+	spec.Source.Namespace = mig.Namespace
+	if interrupted && mig.Status.Strategy != "" {
+		spec.Strategy = mig.Status.Strategy
+	}
+	m := spec.Migrator(ctx, c.kubeOpts)
+	m.ExcludedStrategies = unsupportedStrategies
+	if mig.Status.MigrationID != "" {
+		m.MigrationID = mig.Status.MigrationID
+	}
+	m.OnStage = func(stage int, description string) {
+		_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+			s.Stage = fmt.Sprintf("%d: %s", stage, description)
+		})
+	}
+	// The migration ID has to be stored before any object is created, so they can
+	// be found again if the operator is restarted
+	err := c.updateStatus(mig, func(s *PVCMigrationStatus) {
+		s.Phase = PhaseRunning
+		s.MigrationID = m.MigrationID
+		s.Message = ""
+		if s.StartTime == nil {
+			s.StartTime = &metav1.Time{Time: time.Now()}
+		}
+	})
+	if err != nil {
+		return
+	}
+	l = l.WithField("migration-id", m.MigrationID)
+
+	if interrupted {
+		intact, err := c.cleanupInterrupted(ctx, m)
+		if err != nil {
+			c.fail(mig, ConditionCompleted, "RecoveryFailed", err)
+			return
+		}
+		if !intact {
+			l.Info("Source PVC was already removed, resuming migration")
+			_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+				s.Stage = "resuming"
+				setCondition(s, ConditionCopying, metav1.ConditionTrue, "Resuming", "Resuming migration after the operator was restarted")
+			})
+			c.finish(ctx, mig, m, m.Resume())
+			return
+		}
+		l.Info("Source PVC is intact, restarting migration")
+	}
+
+	_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+		s.Stage = "validating"
+	})
+	err = m.Prepare()
+	if err != nil {
+		if interrupted && m.Verify() == nil {
+			// The migration was interrupted after the destination was in place already,
+			// for example after a PVC was expanded in-place
+			l.WithError(err).Info("Validation failed, but destination is already migrated")
+			c.finish(ctx, mig, m, nil)
+			return
+		}
+		c.fail(mig, ConditionValidated, "ValidationFailed", err)
+		return
+	}
+	_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+		s.Strategy = m.Selected().Identifier()
+		setCondition(s, ConditionValidated, metav1.ConditionTrue, "Validated", "Migration was validated")
+		setCondition(s, ConditionCopying, metav1.ConditionTrue, "Copying", "Strategy "+s.Strategy+" is running")
+	})
+	c.finish(ctx, mig, m, m.Run())
+}
+
+// finish reports the result of the strategy, and verifies the destination if it succeeded.
+func (c *Controller) finish(ctx context.Context, mig *PVCMigration, m *migrator.Migrator, err error) {
+	l := c.log.WithField("pvcmigration", key(mig.Namespace, mig.Name))
+	if c.ctx.Err() != nil {
+		// The operator is stopping, the strategy cleaned up or rolled back already.
+		// The migration stays running, so the next instance picks it up again
+		l.Warning("Operator stopped during migration, migration will be recovered on the next start")
+		return
+	}
+	if err != nil {
+		c.fail(mig, ConditionCopying, "CopyFailed", err)
+		return
+	}
+	_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+		s.Stage = "verifying"
+		setCondition(s, ConditionCopying, metav1.ConditionFalse, "CopyFinished", "Data was migrated")
+	})
+	err = m.Verify()
+	if err != nil {
+		c.fail(mig, ConditionVerified, "VerificationFailed", err)
+		return
+	}
+	l.Info("Migration completed")
+	_ = c.updateStatus(mig, func(s *PVCMigrationStatus) {
+		s.Phase = PhaseSucceeded
+		s.Stage = ""
+		s.Message = "Migration completed"
+		s.CompletionTime = &metav1.Time{Time: time.Now()}
+		setCondition(s, ConditionVerified, metav1.ConditionTrue, "Verified", "Destination PVC matches the requested properties")
+		setCondition(s, ConditionCompleted, metav1.ConditionTrue, "Completed", "Migration completed")
+	})
+}
+
+// cleanupInterrupted removes the objects left behind by a migration which was interrupted by a
+// restart of the operator, and returns whether the source PVC is still intact. If it isn't, the
+// temporary PVCs of the migration are kept, as they might hold the only copy of the data.
+func (c *Controller) cleanupInterrupted(ctx context.Context, m *migrator.Migrator) (bool, error) {
+	l := c.log.WithField("migration-id", m.MigrationID)
+	source, err := c.kClient.CoreV1().PersistentVolumeClaims(m.SourceNamespace).Get(ctx, m.SourcePVCName, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	// A source PVC with the ID of the migration was recreated by it, and might be incomplete
+	intact := err == nil && source.Labels[config.LabelMigrationID] != m.MigrationID
+
+	f := cleanup.NewFinder(ctx, c.kClient)
+	f.Namespace = m.SourceNamespace
+	leftovers, err := f.Find()
+	if err != nil {
+		return false, err
+	}
+	jobs := []cleanup.Leftover{}
+	for _, lo := range leftovers {
+		if lo.MigrationID != m.MigrationID {
+			continue
+		}
+		ll := l.WithField("kind", lo.Kind).WithField("name", lo.Name)
+		if lo.Kind == cleanup.KindPVC && !intact {
+			ll.Debug("Keeping temporary PVC to resume migration")
+			continue
+		}
+		if !lo.Safe {
+			ll.WithField("reason", lo.Reason).Warning("Not removing leftover object")
+			continue
+		}
+		err = lo.Delete(ctx, c.kClient)
+		if err != nil {
+			return false, err
+		}
+		ll.Info("Removed leftover object")
+		if lo.Kind == cleanup.KindJob {
+			jobs = append(jobs, lo)
+		}
+	}
+	// New mover jobs might use the same names, so wait until the old ones are gone
+	err = wait.PollUntilContextTimeout(ctx, 2*time.Second, config.CleanupTimeout, true, func(ctx context.Context) (bool, error) {
+		for _, lo := range jobs {
+			_, err := c.kClient.BatchV1().Jobs(lo.Namespace).Get(ctx, lo.Name, metav1.GetOptions{})
+			if !errors.IsNotFound(err) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	return intact, err
+}
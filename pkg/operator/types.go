@@ -0,0 +1,139 @@
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"beryju.org/korb/v2/pkg/plan"
+)
+
+// PVCMigrationResource is the resource of the PVCMigration custom resource, see crd.yaml.
+var PVCMigrationResource = schema.GroupVersionResource{
+	Group:    "korb.beryju.org",
+	Version:  "v1alpha1",
+	Resource: "pvcmigrations",
+}
+
+type Phase string
+
+const (
+	PhasePending   Phase = "Pending"
+	PhaseRunning   Phase = "Running"
+	PhaseSucceeded Phase = "Succeeded"
+	PhaseFailed    Phase = "Failed"
+)
+
+// Condition types set in the status of a PVCMigration
+const (
+	ConditionValidated = "Validated"
+	ConditionCopying   = "Copying"
+	ConditionVerified  = "Verified"
+	ConditionCompleted = "Completed"
+	ConditionFailed    = "Failed"
+)
+
+// PVCMigration requests the migration of a single PVC. It is reconciled by `korb operator`.
+type PVCMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PVCMigrationSpec   `json:"spec"`
+	Status PVCMigrationStatus `json:"status,omitempty"`
+}
+
+// PVCMigrationSpec has the same fields as a migration in a plan file, and an optional schedule.
+type PVCMigrationSpec struct {
+	plan.Migration `json:",inline"`
+
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// Schedule limits when a migration is started. Running migrations are not stopped
+// at the end of the window.
+type Schedule struct {
+	// NotBefore is the earliest time the migration is started
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	// WindowStart and WindowEnd limit the start of the migration to a daily window, in the
+	// format HH:MM. The window may wrap around midnight.
+	WindowStart string `json:"windowStart,omitempty"`
+	WindowEnd   string `json:"windowEnd,omitempty"`
+	// TimeZone of the window, UTC if empty
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+type PVCMigrationStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	Phase              Phase `json:"phase,omitempty"`
+	// Stage is the stage the strategy is currently in
+	Stage    string `json:"stage,omitempty"`
+	Strategy string `json:"strategy,omitempty"`
+	// MigrationID is set on all objects created by the migration, and is used to find them
+	// if the operator is restarted during the migration
+	MigrationID    string             `json:"migrationID,omitempty"`
+	Message        string             `json:"message,omitempty"`
+	StartTime      *metav1.Time       `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time       `json:"completionTime,omitempty"`
+	Conditions     []metav1.Condition `json:"conditions,omitempty"`
+}
+
+func fromUnstructured(u *unstructured.Unstructured) (*PVCMigration, error) {
+	mig := &PVCMigration{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, mig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PVCMigration %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return mig, nil
+}
+
+// Allows checks whether a migration may be started at the given time.
+func (s *Schedule) Allows(now time.Time) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+	if s.NotBefore != nil && now.Before(s.NotBefore.Time) {
+		return false, nil
+	}
+	if s.WindowStart == "" && s.WindowEnd == "" {
+		return true, nil
+	}
+	loc := time.UTC
+	if s.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return false, fmt.Errorf("invalid time zone '%s': %w", s.TimeZone, err)
+		}
+	}
+	start, err := parseTimeOfDay(s.WindowStart)
+	if err != nil {
+		return false, fmt.Errorf("invalid window start: %w", err)
+	}
+	end, err := parseTimeOfDay(s.WindowEnd)
+	if err != nil {
+		return false, fmt.Errorf("invalid window end: %w", err)
+	}
+	local := now.In(loc)
+	current := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	if start <= end {
+		return current >= start && current < end, nil
+	}
+	// The window wraps around midnight
+	return current >= start || current < end, nil
+}
+
+// parseTimeOfDay parses HH:MM into the duration since midnight. An empty value is midnight.
+func parseTimeOfDay(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
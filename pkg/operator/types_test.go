@@ -0,0 +1,87 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScheduleAllows(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	for _, tc := range []struct {
+		name     string
+		schedule *Schedule
+		now      string
+		allowed  bool
+		err      bool
+	}{
+		{name: "no schedule", now: "2026-10-19T12:00:00Z", allowed: true},
+		{name: "empty schedule", schedule: &Schedule{}, now: "2026-10-19T12:00:00Z", allowed: true},
+		{
+			name:     "before not before",
+			schedule: &Schedule{NotBefore: &metav1.Time{Time: at("2026-10-20T00:00:00Z")}},
+			now:      "2026-10-19T23:59:00Z",
+		},
+		{
+			name:     "after not before",
+			schedule: &Schedule{NotBefore: &metav1.Time{Time: at("2026-10-20T00:00:00Z")}},
+			now:      "2026-10-20T00:00:00Z",
+			allowed:  true,
+		},
+		{name: "inside window", schedule: &Schedule{WindowStart: "08:00", WindowEnd: "17:00"}, now: "2026-10-19T12:00:00Z", allowed: true},
+		{name: "window start is inclusive", schedule: &Schedule{WindowStart: "08:00", WindowEnd: "17:00"}, now: "2026-10-19T08:00:00Z", allowed: true},
+		{name: "window end is exclusive", schedule: &Schedule{WindowStart: "08:00", WindowEnd: "17:00"}, now: "2026-10-19T17:00:00Z"},
+		{name: "before window", schedule: &Schedule{WindowStart: "08:00", WindowEnd: "17:00"}, now: "2026-10-19T07:59:00Z"},
+		{name: "across midnight before midnight", schedule: &Schedule{WindowStart: "22:00", WindowEnd: "04:00"}, now: "2026-10-19T23:30:00Z", allowed: true},
+		{name: "across midnight after midnight", schedule: &Schedule{WindowStart: "22:00", WindowEnd: "04:00"}, now: "2026-10-20T03:59:00Z", allowed: true},
+		{name: "across midnight outside", schedule: &Schedule{WindowStart: "22:00", WindowEnd: "04:00"}, now: "2026-10-19T12:00:00Z"},
+		{name: "only window start", schedule: &Schedule{WindowStart: "22:00"}, now: "2026-10-19T23:00:00Z", allowed: true},
+		{name: "only window end", schedule: &Schedule{WindowEnd: "04:00"}, now: "2026-10-19T03:00:00Z", allowed: true},
+		{
+			// 22:30 in Berlin (CEST, UTC+2)
+			name:     "time zone inside window",
+			schedule: &Schedule{WindowStart: "22:00", WindowEnd: "04:00", TimeZone: "Europe/Berlin"},
+			now:      "2026-10-19T20:30:00Z",
+			allowed:  true,
+		},
+		{
+			// 23:30 UTC would be inside the window in UTC, but is already 01:30 in Berlin
+			name:     "time zone across midnight",
+			schedule: &Schedule{WindowStart: "22:00", WindowEnd: "01:00", TimeZone: "Europe/Berlin"},
+			now:      "2026-10-19T23:30:00Z",
+		},
+		{
+			// 02:30 in New York (EDT, UTC-4)
+			name:     "time zone behind UTC",
+			schedule: &Schedule{WindowStart: "01:00", WindowEnd: "03:00", TimeZone: "America/New_York"},
+			now:      "2026-10-19T06:30:00Z",
+			allowed:  true,
+		},
+		{name: "invalid time zone", schedule: &Schedule{WindowStart: "22:00", WindowEnd: "04:00", TimeZone: "Nowhere/City"}, now: "2026-10-19T12:00:00Z", err: true},
+		{name: "invalid window start", schedule: &Schedule{WindowStart: "25:00", WindowEnd: "04:00"}, now: "2026-10-19T12:00:00Z", err: true},
+		{name: "invalid window end", schedule: &Schedule{WindowStart: "22:00", WindowEnd: "4pm"}, now: "2026-10-19T12:00:00Z", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			allowed, err := tc.schedule.Allows(at(tc.now))
+			if tc.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != tc.allowed {
+				t.Errorf("got allowed %t, expected %t", allowed, tc.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package plan
+
+import (
+	"context"
+
+	"beryju.org/korb/v2/pkg/kube"
+	"beryju.org/korb/v2/pkg/migrator"
+)
+
+// Migrator creates a Migrator configured for this migration. The plan must have been validated before.
+func (mi Migration) Migrator(ctx context.Context, kubeOpts kube.Options) *migrator.Migrator {
+	m := migrator.New(ctx, kubeOpts, mi.Strategy, mi.Mover.TolerateAnyNode)
+	m.Force = mi.Force
+	m.SkipUsageCheck = mi.SkipUsageCheck
+	m.OfflineResize = mi.OfflineResize
+	m.ArchivePath = mi.Archive
+	m.ImportMode = mi.ImportMode
+	m.WaitForTempDestPVCBind = !mi.Mover.SkipPVCBindWait
+	if mi.Mover.RetainSourcePV != nil {
+		m.RetainSourcePV = *mi.Mover.RetainSourcePV
+	}
+	m.RsyncOptions = mi.Mover.Rsync
+	m.Filter = mi.Filter
+	m.Ownership = mi.Ownership
+	m.Mappings = mi.Mappings
+	m.Timeout, _ = mi.Mover.timeout()
+	m.CopyTimeout, _ = mi.Mover.copyTimeout()
+
+	if mi.Source.Namespace != "" {
+		m.SourceNamespace = mi.Source.Namespace
+		m.DestNamespace = mi.Source.Namespace
+	}
+	m.SourcePVCName = mi.Source.Name
+	m.SourceSubPath = mi.Source.SubPath
+
+	m.DestPVCName = mi.Destination.Name
+	m.DestPVCStorageClass = mi.Destination.StorageClass
+	m.DestPVCSize = mi.Destination.Size
+	m.DestPVCAccessModes = mi.Destination.AccessModes
+	m.DestSubPath = mi.Destination.SubPath
+	return m
+}
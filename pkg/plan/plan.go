@@ -0,0 +1,222 @@
+package plan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+
+	"beryju.org/korb/v2/pkg/mover"
+	"beryju.org/korb/v2/pkg/strategies"
+)
+
+// Plan is a list of migrations, which can be stored as YAML or JSON and applied with `korb apply`.
+type Plan struct {
+	Migrations []Migration `json:"migrations"`
+}
+
+type Migration struct {
+	Source      Source      `json:"source"`
+	Destination Destination `json:"destination,omitempty"`
+	Strategy    string      `json:"strategy,omitempty"`
+	Force       bool        `json:"force,omitempty"`
+	// SkipUsageCheck skips measuring the used space on the source PVC
+	SkipUsageCheck bool `json:"skipUsageCheck,omitempty"`
+	// OfflineResize scales down controllers using the PVC while the expand strategy resizes it
+	OfflineResize bool `json:"offlineResize,omitempty"`
+	// Archive is the path of the archive for the export and import strategies
+	Archive string `json:"archive,omitempty"`
+	// ImportMode decides how the import strategy handles existing data, merge by default
+	ImportMode string `json:"importMode,omitempty"`
+	// Filter selects which paths are copied, exported and imported
+	Filter mover.Filter `json:"filter,omitempty"`
+	// Ownership changes the owner and permissions of copied and imported files
+	Ownership mover.Ownership `json:"ownership,omitempty"`
+	// Mappings copy directories between PVCs with the remap strategy, the source must be one of their sources
+	Mappings []mover.Mapping `json:"mappings,omitempty"`
+	Mover    MoverOptions    `json:"mover,omitempty"`
+}
+
+type Source struct {
+	// Namespace of the source PVC, if empty the namespace from the kubeconfig is used.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// SubPath only copies this directory of the source
+	SubPath string `json:"subPath,omitempty"`
+}
+
+// Destination overrides properties of the new PVC, empty fields are taken from the source PVC.
+type Destination struct {
+	Name         string   `json:"name,omitempty"`
+	StorageClass string   `json:"storageClass,omitempty"`
+	Size         string   `json:"size,omitempty"`
+	AccessModes  []string `json:"accessModes,omitempty"`
+	// SubPath is the directory of the destination the data is copied into
+	SubPath string `json:"subPath,omitempty"`
+}
+
+type MoverOptions struct {
+	Timeout         string `json:"timeout,omitempty"`
+	CopyTimeout     string `json:"copyTimeout,omitempty"`
+	TolerateAnyNode bool   `json:"tolerateAnyNode,omitempty"`
+	SkipPVCBindWait bool   `json:"skipPVCBindWait,omitempty"`
+	RetainSourcePV  *bool  `json:"retainSourcePV,omitempty"`
+	// Rsync options for sync movers
+	Rsync mover.RsyncOptions `json:"rsync,omitempty"`
+}
+
+// Load reads a plan from path, or from stdin if path is "-".
+func Load(path string) (*Plan, error) {
+	var raw []byte
+	var err error
+	if path == "-" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{}
+	err = yaml.UnmarshalStrict(raw, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	return p, nil
+}
+
+var accessModes = map[v1.PersistentVolumeAccessMode]struct{}{
+	v1.ReadWriteOnce:    {},
+	v1.ReadOnlyMany:     {},
+	v1.ReadWriteMany:    {},
+	v1.ReadWriteOncePod: {},
+}
+
+// SetDefaultNamespace sets the source namespace of all migrations which don't have one,
+// so migrations in the default namespace are compared correctly by Validate.
+func (p *Plan) SetDefaultNamespace(namespace string) {
+	for idx := range p.Migrations {
+		if p.Migrations[idx].Source.Namespace == "" {
+			p.Migrations[idx].Source.Namespace = namespace
+		}
+	}
+}
+
+// Validate checks all migrations of the plan without connecting to the cluster,
+// and returns all problems found. SetDefaultNamespace should be called before.
+func (p *Plan) Validate() error {
+	if len(p.Migrations) == 0 {
+		return errors.New("plan does not contain any migrations")
+	}
+	known := map[string]struct{}{}
+	for _, s := range strategies.StrategyInstances(strategies.NewBaseStrategy(&strategies.BaseStrategyOpts{})) {
+		known[s.Identifier()] = struct{}{}
+	}
+	errs := []error{}
+	sources := map[string]int{}
+	for idx, m := range p.Migrations {
+		key := m.Source.Namespace + "/" + m.Source.Name
+		if _, ok := sources[key]; !ok {
+			sources[key] = idx
+		}
+	}
+	seen := map[string]int{}
+	seenDest := map[string]int{}
+	for idx, m := range p.Migrations {
+		fail := func(format string, a ...interface{}) {
+			errs = append(errs, fmt.Errorf("migration %d (%s): %s", idx, m.Source.Name, fmt.Sprintf(format, a...)))
+		}
+		if m.Source.Name == "" {
+			fail("source name is required")
+		}
+		key := m.Source.Namespace + "/" + m.Source.Name
+		if other, ok := seen[key]; ok {
+			fail("source is already migrated by migration %d", other)
+		}
+		seen[key] = idx
+		// The destination is created in the namespace of the source, and keeps the name of the source
+		// if it doesn't have one
+		destName := m.Destination.Name
+		if destName == "" {
+			destName = m.Source.Name
+		}
+		destKey := m.Source.Namespace + "/" + destName
+		if other, ok := seenDest[destKey]; ok {
+			fail("destination %s is already created by migration %d", destName, other)
+		}
+		seenDest[destKey] = idx
+		if other, ok := sources[destKey]; ok && destKey != key {
+			fail("destination %s is the source of migration %d", destName, other)
+		}
+		if m.Destination.Size != "" {
+			if _, err := resource.ParseQuantity(m.Destination.Size); err != nil {
+				fail("invalid destination size '%s': %v", m.Destination.Size, err)
+			}
+		}
+		for _, am := range m.Destination.AccessModes {
+			if _, ok := accessModes[v1.PersistentVolumeAccessMode(am)]; !ok {
+				fail("invalid access mode '%s'", am)
+			}
+		}
+		if m.Strategy != "" {
+			if _, ok := known[m.Strategy]; !ok {
+				fail("unknown strategy '%s'", m.Strategy)
+			}
+		}
+		if m.ImportMode != "" && !slices.Contains(strategies.ImportModes, m.ImportMode) {
+			fail("unknown import mode '%s'", m.ImportMode)
+		}
+		if err := mover.ValidateSubPath(m.Source.SubPath); err != nil {
+			fail("invalid source: %v", err)
+		}
+		if err := mover.ValidateSubPath(m.Destination.SubPath); err != nil {
+			fail("invalid destination: %v", err)
+		}
+		if err := m.Filter.Validate(); err != nil {
+			fail("invalid filter: %v", err)
+		}
+		if err := m.Ownership.Validate(); err != nil {
+			fail("invalid ownership: %v", err)
+		}
+		if len(m.Mappings) > 0 {
+			if err := mover.ValidateMappings(m.Mappings); err != nil {
+				fail("invalid mappings: %v", err)
+			}
+			if !slices.Contains(mover.SourcePVCs(m.Mappings), m.Source.Name) {
+				fail("source is not a source of the mappings")
+			}
+		}
+		if _, err := m.Mover.timeout(); err != nil {
+			fail("invalid timeout: %v", err)
+		}
+		if _, err := m.Mover.copyTimeout(); err != nil {
+			fail("invalid copy timeout: %v", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o MoverOptions) timeout() (*time.Duration, error) {
+	return parseDuration(o.Timeout)
+}
+
+func (o MoverOptions) copyTimeout() (*time.Duration, error) {
+	return parseDuration(o.CopyTimeout)
+}
+
+func parseDuration(raw string) (*time.Duration, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,115 @@
+package plan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		migrations []Migration
+		// errs are parts of the expected errors, in order. The plan is valid if empty
+		errs []string
+	}{
+		{name: "empty", errs: []string{"does not contain any migrations"}},
+		{
+			name: "valid",
+			migrations: []Migration{
+				{Source: Source{Name: "a"}, Destination: Destination{StorageClass: "ssd", Size: "10Gi", AccessModes: []string{"ReadWriteOnce"}}},
+				{Source: Source{Name: "b"}, Strategy: "copy-twice-name", Mover: MoverOptions{Timeout: "5m", CopyTimeout: "1h"}},
+			},
+		},
+		{name: "missing source", migrations: []Migration{{}}, errs: []string{"source name is required"}},
+		{
+			name:       "invalid destination",
+			migrations: []Migration{{Source: Source{Name: "a"}, Destination: Destination{Size: "lots", AccessModes: []string{"ReadWriteSometimes"}}}},
+			errs:       []string{"invalid destination size 'lots'", "invalid access mode 'ReadWriteSometimes'"},
+		},
+		{name: "unknown strategy", migrations: []Migration{{Source: Source{Name: "a"}, Strategy: "teleport"}}, errs: []string{"unknown strategy 'teleport'"}},
+		{
+			name:       "invalid timeouts",
+			migrations: []Migration{{Source: Source{Name: "a"}, Mover: MoverOptions{Timeout: "soon", CopyTimeout: "1 hour"}}},
+			errs:       []string{"invalid timeout", "invalid copy timeout"},
+		},
+		{
+			name:       "errors of all migrations",
+			migrations: []Migration{{Source: Source{Name: "a"}, Strategy: "teleport"}, {Source: Source{Name: "b"}, Destination: Destination{Size: "lots"}}},
+			errs:       []string{"migration 0 (a): unknown strategy", "migration 1 (b): invalid destination size"},
+		},
+		{
+			name:       "duplicate source",
+			migrations: []Migration{{Source: Source{Name: "a"}, Destination: Destination{Name: "b"}}, {Source: Source{Name: "a"}, Destination: Destination{Name: "c"}}},
+			errs:       []string{"source is already migrated by migration 0"},
+		},
+		{
+			name: "same name in other namespaces",
+			migrations: []Migration{
+				{Source: Source{Namespace: "prod", Name: "a"}},
+				{Source: Source{Namespace: "staging", Name: "a"}},
+			},
+		},
+		{
+			name:       "duplicate source in the default namespace",
+			migrations: []Migration{{Source: Source{Namespace: "default", Name: "a"}, Destination: Destination{Name: "b"}}, {Source: Source{Name: "a"}, Destination: Destination{Name: "c"}}},
+			errs:       []string{"source is already migrated by migration 0"},
+		},
+		{
+			name:       "duplicate destination",
+			migrations: []Migration{{Source: Source{Name: "a"}, Destination: Destination{Name: "c"}}, {Source: Source{Name: "b"}, Destination: Destination{Name: "c"}}},
+			errs:       []string{"destination c is already created by migration 0"},
+		},
+		{
+			name: "same destination in other namespaces",
+			migrations: []Migration{
+				{Source: Source{Namespace: "prod", Name: "a"}, Destination: Destination{Name: "c"}},
+				{Source: Source{Namespace: "staging", Name: "b"}, Destination: Destination{Name: "c"}},
+			},
+		},
+		{name: "destination with the name of its source", migrations: []Migration{{Source: Source{Name: "a"}, Destination: Destination{Name: "a"}}}},
+		{
+			name:       "destination with the name of another source",
+			migrations: []Migration{{Source: Source{Name: "a"}, Destination: Destination{Name: "b"}}, {Source: Source{Name: "c"}, Destination: Destination{Name: "a"}}},
+			errs:       []string{"destination a is the source of migration 0"},
+		},
+		{
+			name:       "destination without a name",
+			migrations: []Migration{{Source: Source{Name: "a"}}, {Source: Source{Name: "b"}, Destination: Destination{Name: "a"}}},
+			errs:       []string{"destination a is already created by migration 0", "destination a is the source of migration 0"},
+		},
+		{
+			name:       "swapped names",
+			migrations: []Migration{{Source: Source{Name: "a"}, Destination: Destination{Name: "b"}}, {Source: Source{Name: "b"}, Destination: Destination{Name: "a"}}},
+			errs:       []string{"migration 0 (a): destination b is the source of migration 1", "migration 1 (b): destination a is the source of migration 0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := Plan{Migrations: tc.migrations}
+			p.SetDefaultNamespace("default")
+			checkErrors(t, p.Validate(), tc.errs)
+		})
+	}
+}
+
+// checkErrors checks that err contains one error for each of the expected parts, in order.
+func checkErrors(t *testing.T, err error, expected []string) {
+	t.Helper()
+	if len(expected) == 0 {
+		if err != nil {
+			t.Errorf("expected plan to be valid: %v", err)
+		}
+		return
+	}
+	if err == nil {
+		t.Fatalf("expected errors %v", expected)
+	}
+	got := strings.Split(err.Error(), "\n")
+	if len(got) != len(expected) {
+		t.Fatalf("got %d errors, expected %d: %v", len(got), len(expected), err)
+	}
+	for idx, e := range expected {
+		if !strings.Contains(got[idx], e) {
+			t.Errorf("got error %q, expected it to contain %q", got[idx], e)
+		}
+	}
+}
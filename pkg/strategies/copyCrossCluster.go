@@ -0,0 +1,196 @@
+// flag: copy-cross-cluster
+// Behavior: Create the PVC in another cluster, and stream the data from a mover in the source cluster through korb into a mover in the destination cluster.
+
+package strategies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/mover"
+)
+
+type CopyCrossClusterStrategy struct {
+	BaseStrategy
+
+	DestPVC *v1.PersistentVolumeClaim
+
+	sourceMover *mover.MoverJob
+	destMover   *mover.MoverJob
+}
+
+func NewCopyCrossClusterStrategy(b BaseStrategy) *CopyCrossClusterStrategy {
+	s := &CopyCrossClusterStrategy{
+		BaseStrategy: b,
+	}
+	s.log = s.log.WithField("strategy", s.Identifier())
+	return s
+}
+
+func (c *CopyCrossClusterStrategy) Identifier() string {
+	return "copy-cross-cluster"
+}
+
+func (c *CopyCrossClusterStrategy) CompatibleWithContext(ctx MigrationContext) error {
+	if c.destKClient == nil {
+		return errors.New("no destination cluster configured")
+	}
+	if !c.rsyncOptions.Empty() {
+		return errors.New("copy-cross-cluster streams a tar archive, and doesn't use rsync options")
+	}
+	return nil
+}
+
+func (c *CopyCrossClusterStrategy) SupportsCrossCluster() bool {
+	return true
+}
+
+func (c *CopyCrossClusterStrategy) Description() string {
+	return "Create the PVC in another cluster, and stream the data through korb."
+}
+
+func (c *CopyCrossClusterStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim, WaitForTempDestPVCBind bool) error {
+	c.stage(1, "creating destination PVC")
+	destInst, err := c.destKClient.CoreV1().PersistentVolumeClaims(destTemplate.Namespace).Create(c.ctx, destTemplate, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	c.DestPVC = destInst
+
+	if WaitForTempDestPVCBind {
+		err = c.waitForBound(destInst)
+		if err != nil {
+			c.log.WithError(err).Warning("Waiting for PVC to be bound failed")
+			return errors.Join(err, c.cleanupDestPVC())
+		}
+	} else {
+		c.stage(2, "skipping waiting for PVC to be bound")
+	}
+
+	c.stage(2, "starting mover jobs")
+	c.sourceMover = mover.NewMoverJob(c.ctx, c.kClient, mover.MoverTypeSleep, c.tolerateAllNodes)
+	c.sourceMover.Namespace = sourcePVC.Namespace
+	c.sourceMover.SourceVolume = sourcePVC
+	c.sourceMover.Name = fmt.Sprintf("korb-job-%s", sourcePVC.UID)
+	c.sourceMover.MigrationID = c.migrationID
+	c.sourceMover.SourceSubPath = c.sourceSubPath
+
+	// In sleep mode, the mover only mounts its source volume, so the destination PVC is
+	// mounted at mover.SourceMount in the destination cluster
+	c.destMover = mover.NewMoverJob(c.ctx, c.destKClient, mover.MoverTypeSleep, c.tolerateAllNodes)
+	c.destMover.Namespace = destInst.Namespace
+	c.destMover.SourceVolume = destInst
+	c.destMover.Name = fmt.Sprintf("korb-job-%s", destInst.UID)
+	c.destMover.MigrationID = c.migrationID
+	c.destMover.SourceSubPath = c.destSubPath
+
+	sourcePod := c.sourceMover.Start().WaitForRunning(c.timeout)
+	destPod := c.destMover.Start().WaitForRunning(c.timeout)
+	if sourcePod == nil || destPod == nil {
+		err = errors.New("mover pods not in correct state")
+		c.log.WithError(err).Warning("Failed to move data")
+		return errors.Join(err, c.Cleanup(), c.cleanupDestPVC())
+	}
+
+	c.stage(3, "mover pods running, starting copy")
+	err = c.stream(*sourcePod, *destPod)
+	if err != nil {
+		c.log.WithError(err).Warning("Failed to move data")
+		return errors.Join(err, c.Cleanup(), c.cleanupDestPVC())
+	}
+	err = c.removeMigrationLabel(c.destKClient, c.DestPVC)
+	if err != nil {
+		c.log.WithError(err).WithField("pvc-name", c.DestPVC.Name).Warning("failed to remove migration label from destination PVC")
+	}
+	c.log.Info("And we're done")
+	return c.Cleanup()
+}
+
+// stream runs tar in both movers, and copies the archive from the source to the destination
+// without storing it locally
+func (c *CopyCrossClusterStrategy) stream(sourcePod v1.Pod, destPod v1.Pod) error {
+	sourceCmd, err := c.sourceMover.TarCreate(sourcePod, c.kConfig, mover.SourceMount, "czf", c.filter)
+	if err != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	bar := progressbar.DefaultBytes(
+		-1,
+		"copying",
+	)
+	sourceErr := make(chan error, 1)
+	go func() {
+		err := c.sourceMover.Exec(sourcePod, c.kConfig, sourceCmd, nil, io.MultiWriter(writer, bar))
+		// Closing the writer ends the stdin of the destination tar
+		writer.CloseWithError(err)
+		sourceErr <- err
+	}()
+	cmd := append([]string{
+		"bash",
+		"-c",
+		fmt.Sprintf(`cd "%s" && tar xzf - "$@"`, mover.SourceMount),
+		"bash",
+	}, c.ownership.TarArgs()...)
+	err = c.destMover.Exec(destPod, c.destKConfig, cmd, reader, os.Stdout)
+	if err != nil {
+		reader.CloseWithError(err)
+	}
+	err = errors.Join(<-sourceErr, err)
+	if err != nil {
+		return err
+	}
+	return c.destMover.ApplyOwnership(destPod, c.destKConfig, mover.SourceMount, c.ownership)
+}
+
+func (c *CopyCrossClusterStrategy) waitForBound(p *v1.PersistentVolumeClaim) error {
+	return wait.PollUntilContextTimeout(c.ctx, 2*time.Second, c.timeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := c.destKClient.CoreV1().PersistentVolumeClaims(p.ObjectMeta.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if pvc.Status.Phase != v1.ClaimBound {
+			c.log.WithField("pvc-name", pvc.ObjectMeta.Name).Warning("PVC not bound yet, retrying")
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// cleanupDestPVC removes the destination PVC after a failed copy. The source is never changed
+// by this strategy, so the incomplete destination can always be removed.
+func (c *CopyCrossClusterStrategy) cleanupDestPVC() error {
+	if c.DestPVC == nil {
+		return nil
+	}
+	ctx, cancel := config.CleanupContext(c.ctx)
+	defer cancel()
+	err := c.destKClient.CoreV1().PersistentVolumeClaims(c.DestPVC.Namespace).Delete(ctx, c.DestPVC.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		c.log.WithError(err).WithField("pvc-name", c.DestPVC.Name).Warning("failed to delete incomplete destination PVC")
+		return err
+	}
+	c.log.WithField("pvc-name", c.DestPVC.Name).Info("Deleted incomplete destination PVC")
+	return nil
+}
+
+func (c *CopyCrossClusterStrategy) Cleanup() error {
+	c.log.Info("Cleaning up...")
+	errs := []error{}
+	for _, m := range []*mover.MoverJob{c.sourceMover, c.destMover} {
+		if m != nil {
+			errs = append(errs, m.Cleanup())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -5,14 +5,18 @@ package strategies
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
 
+	"beryju.org/korb/v2/pkg/config"
 	"beryju.org/korb/v2/pkg/mover"
 )
 
@@ -21,9 +25,11 @@ type CopyTwiceNameStrategy struct {
 
 	DestPVC     *v1.PersistentVolumeClaim
 	TempDestPVC *v1.PersistentVolumeClaim
+	RetainedPV  *v1.PersistentVolume
 
-	tempMover  *mover.MoverJob
-	finalMover *mover.MoverJob
+	tempMover     *mover.MoverJob
+	finalMover    *mover.MoverJob
+	rollbackMover *mover.MoverJob
 
 	MoveTimeout time.Duration
 
@@ -46,6 +52,10 @@ func (c *CopyTwiceNameStrategy) Identifier() string {
 }
 
 func (c *CopyTwiceNameStrategy) CompatibleWithContext(ctx MigrationContext) error {
+	if c.sourceSubPath != "" {
+		// Everything outside of the subpath would be lost when the source is deleted
+		return fmt.Errorf("copy-twice-name deletes the source PVC, so it can't copy a subpath of it")
+	}
 	return nil
 }
 
@@ -62,12 +72,24 @@ func (c *CopyTwiceNameStrategy) getDeleteOptions() metav1.DeleteOptions {
 
 func (c *CopyTwiceNameStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim, WaitForTempDestPVCBind bool) error {
 	c.setTimeout(destTemplate)
+	c.WaitForTempDestPVCBind = WaitForTempDestPVCBind
 	c.log.Warning("This strategy assumes you've stopped all pods accessing this data.")
 	suffix := time.Now().Unix()
 	tempDest := destTemplate.DeepCopy()
 	tempDest.Name = fmt.Sprintf("%s-copy-%d", tempDest.Name, suffix)
+	// Keep the labels of the destination, so they can be restored when resuming from the temporary PVC
+	tempDest.Labels = map[string]string{}
+	for k, v := range destTemplate.Labels {
+		tempDest.Labels[k] = v
+	}
+	for k, v := range config.Labels(c.migrationID, config.RoleTempPVC) {
+		tempDest.Labels[k] = v
+	}
+	tempDest.Annotations = map[string]string{
+		config.AnnotationSourcePVC: sourcePVC.Namespace + "/" + sourcePVC.Name,
+	}
 
-	c.log.WithField("stage", 1).Debug("creating temporary PVC")
+	c.stage(1, "creating temporary PVC")
 	tempDestInst, err := c.kClient.CoreV1().PersistentVolumeClaims(destTemplate.ObjectMeta.Namespace).Create(c.ctx, tempDest, metav1.CreateOptions{})
 	c.TempDestPVC = tempDestInst
 	if err != nil {
@@ -78,59 +100,93 @@ func (c *CopyTwiceNameStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemp
 		err = c.waitForBound(tempDest)
 		if err != nil {
 			c.log.WithError(err).Warning("Waiting for PVC to be bound failed")
-			return c.Cleanup()
+			c.pvcsToDelete = []*v1.PersistentVolumeClaim{c.TempDestPVC}
+			c.Cleanup()
+			return err
 		}
 	} else {
-		c.log.WithField("stage", 2).Debug("skipping waiting for PVC to be bound")
+		c.stage(2, "skipping waiting for PVC to be bound")
 	}
 
-	c.log.WithField("stage", 2).Debug("starting mover job")
+	c.stage(2, "starting mover job")
 	c.tempMover = mover.NewMoverJob(c.ctx, c.kClient, mover.MoverTypeSync, c.tolerateAllNodes)
 	c.tempMover.Namespace = destTemplate.Namespace
 	c.tempMover.SourceVolume = sourcePVC
 	c.tempMover.DestVolume = c.TempDestPVC
 	c.tempMover.Name = fmt.Sprintf("korb-job-%s", sourcePVC.UID)
+	c.tempMover.MigrationID = c.migrationID
+	c.tempMover.RsyncOptions = c.rsyncOptions
+	c.tempMover.Filter = c.filter
 	err = c.tempMover.Start().Wait(c.timeout, c.MoveTimeout)
 	if err != nil {
 		c.log.WithError(err).Warning("Failed to move data")
 		c.pvcsToDelete = []*v1.PersistentVolumeClaim{c.TempDestPVC}
-		return c.Cleanup()
+		c.Cleanup()
+		return err
+	}
+
+	if c.retainSourcePV {
+		c.stage(3, "retaining source PersistentVolume")
+		c.RetainedPV, err = c.retainPV(sourcePVC)
+		if err != nil {
+			c.log.WithError(err).Warning("Failed to retain source PersistentVolume")
+			c.pvcsToDelete = []*v1.PersistentVolumeClaim{c.TempDestPVC}
+			c.Cleanup()
+			return err
+		}
 	}
 
-	c.log.WithField("stage", 3).Debug("deleting original PVC")
+	c.stage(3, "deleting original PVC")
 	err = c.kClient.CoreV1().PersistentVolumeClaims(sourcePVC.ObjectMeta.Namespace).Delete(c.ctx, sourcePVC.Name, c.getDeleteOptions())
 	if err != nil {
 		c.log.WithError(err).Warning("Failed to delete source pvc")
-		return c.Cleanup()
+		c.pvcsToDelete = []*v1.PersistentVolumeClaim{c.TempDestPVC}
+		c.Cleanup()
+		return err
 	}
 	err = c.waitForPVCDeletion(sourcePVC)
 	if err != nil {
-		c.log.WithError(err).Warning("failed to delete source pvc")
-		return c.Cleanup()
+		// The source PVC might still be terminating, so the temporary PVC could
+		// end up being the only copy of the data. Never remove it here.
+		c.log.WithError(err).WithField("temp-pvc", c.TempDestPVC.Name).Error("failed to delete source pvc, keeping temporary PVC")
+		c.Cleanup()
+		return err
 	}
 
-	c.log.WithField("stage", 4).Debug("creating final destination PVC")
+	return c.finish(destTemplate, func(err error) error {
+		return c.rollback(sourcePVC, err)
+	})
+}
+
+// finish copies the data from the temporary PVC into the final destination PVC, and removes
+// the temporary PVC. onFail is called if the final PVC couldn't be created or filled.
+func (c *CopyTwiceNameStrategy) finish(destTemplate *v1.PersistentVolumeClaim, onFail func(error) error) error {
+	c.stage(4, "creating final destination PVC")
 	destInst, err := c.kClient.CoreV1().PersistentVolumeClaims(destTemplate.ObjectMeta.Namespace).Create(c.ctx, destTemplate, metav1.CreateOptions{})
 	if err != nil {
 		c.log.WithError(err).Warning("Failed to create final pvc")
-		return c.Cleanup()
+		return onFail(err)
 	}
 	c.DestPVC = destInst
 
-	c.log.WithField("stage", 5).Debug("starting mover job to final PVC")
+	c.stage(5, "starting mover job to final PVC")
 	c.finalMover = mover.NewMoverJob(c.ctx, c.kClient, mover.MoverTypeSync, c.tolerateAllNodes)
 	c.finalMover.Namespace = destTemplate.Namespace
 	c.finalMover.SourceVolume = c.TempDestPVC
 	c.finalMover.DestVolume = c.DestPVC
-	c.finalMover.Name = fmt.Sprintf("korb-job-%s", tempDestInst.UID)
+	c.finalMover.Name = fmt.Sprintf("korb-job-%s", c.TempDestPVC.UID)
+	c.finalMover.MigrationID = c.migrationID
+	c.finalMover.RsyncOptions = c.rsyncOptions
+	c.finalMover.DestSubPath = c.destSubPath
+	// Only the final copy changes the ownership, so a rollback from the temporary PVC restores the original
+	c.finalMover.Ownership = c.ownership
 	err = c.finalMover.Start().Wait(c.timeout, c.MoveTimeout)
 	if err != nil {
 		c.log.WithError(err).Warning("Failed to move data")
-		c.pvcsToDelete = []*v1.PersistentVolumeClaim{c.DestPVC}
-		return c.Cleanup()
+		return onFail(err)
 	}
 
-	c.log.WithField("stage", 6).Debug("deleting temporary PVC")
+	c.stage(6, "deleting temporary PVC")
 	err = c.kClient.CoreV1().PersistentVolumeClaims(destTemplate.ObjectMeta.Namespace).Delete(c.ctx, c.TempDestPVC.Name, c.getDeleteOptions())
 	if err != nil {
 		c.log.WithError(err).Warning("failed to delete temporary destination pvc")
@@ -142,20 +198,220 @@ func (c *CopyTwiceNameStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemp
 		return c.Cleanup()
 	}
 
+	err = c.removeMigrationLabel(c.kClient, c.DestPVC)
+	if err != nil {
+		c.log.WithError(err).WithField("pvc-name", c.DestPVC.Name).Warning("failed to remove migration label from destination PVC")
+	}
+
 	c.log.Info("And we're done")
 
 	return c.Cleanup()
 }
 
+// Resume finishes a migration which was interrupted after the source PVC was deleted. The data is
+// copied from the temporary PVC of the migration into a new destination PVC. A destination PVC
+// left behind by the interrupted migration is replaced, as it might be incomplete.
+func (c *CopyTwiceNameStrategy) Resume(destNamespace string, destName string) error {
+	temps, err := c.kClient.CoreV1().PersistentVolumeClaims(destNamespace).List(c.ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{
+			config.LabelMigrationID: c.migrationID,
+			config.LabelRole:        config.RoleTempPVC,
+		}).String(),
+	})
+	if err != nil {
+		return err
+	}
+	dest, err := c.kClient.CoreV1().PersistentVolumeClaims(destNamespace).Get(c.ctx, destName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	destExists := err == nil
+	if len(temps.Items) == 0 || temps.Items[0].DeletionTimestamp != nil {
+		if destExists {
+			c.log.Info("Temporary PVC was already removed, migration was finished")
+			if dest.Labels[config.LabelMigrationID] == c.migrationID {
+				return c.removeMigrationLabel(c.kClient, dest)
+			}
+			return nil
+		}
+		return fmt.Errorf("no temporary PVC found for migration %s", c.migrationID)
+	}
+	if destExists && dest.Labels[config.LabelMigrationID] != c.migrationID {
+		return fmt.Errorf("PVC %s was not created by migration %s, not replacing it", destName, c.migrationID)
+	}
+	if len(temps.Items) > 1 {
+		return fmt.Errorf("found %d temporary PVCs for migration %s, expected one", len(temps.Items), c.migrationID)
+	}
+	c.TempDestPVC = &temps.Items[0]
+	l := c.log.WithField("temp-pvc", c.TempDestPVC.Name)
+	l.Info("Resuming migration from temporary PVC")
+
+	if destExists {
+		l.WithField("pvc-name", destName).Debug("deleting incomplete destination PVC")
+		err = c.kClient.CoreV1().PersistentVolumeClaims(destNamespace).Delete(c.ctx, destName, c.getDeleteOptions())
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		err = c.waitForPVCDeletion(dest)
+		if err != nil {
+			return err
+		}
+	}
+
+	destLabels := map[string]string{}
+	for k, v := range c.TempDestPVC.Labels {
+		destLabels[k] = v
+	}
+	delete(destLabels, config.LabelManagedBy)
+	delete(destLabels, config.LabelRole)
+	destTemplate := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      destName,
+			Namespace: destNamespace,
+			Labels:    destLabels,
+		},
+		Spec: *c.TempDestPVC.Spec.DeepCopy(),
+	}
+	destTemplate.Spec.VolumeName = ""
+	c.setTimeout(destTemplate)
+	return c.finish(destTemplate, func(err error) error {
+		l.WithError(err).Error("failed to resume migration, data remains in temporary PVC")
+		c.Cleanup()
+		return fmt.Errorf("%w, data remains in temporary PVC %s", err, c.TempDestPVC.Name)
+	})
+}
+
 func (c *CopyTwiceNameStrategy) Cleanup() error {
 	c.log.Info("Cleaning up...")
+	ctx, cancel := config.CleanupContext(c.ctx)
+	defer cancel()
+	errs := []error{}
+	for _, m := range []*mover.MoverJob{c.tempMover, c.finalMover, c.rollbackMover} {
+		if m != nil {
+			errs = append(errs, m.Cleanup())
+		}
+	}
 	for _, pvc := range c.pvcsToDelete {
-		err := c.kClient.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Delete(c.ctx, pvc.Name, metav1.DeleteOptions{})
+		l := c.log.WithField("pvc-name", pvc.Name)
+		err := c.kClient.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			l.WithError(err).Warning("Error during temporary PVC cleanup, PVC was left behind, continuing")
+			errs = append(errs, err)
+			continue
+		}
+		l.Info("Deleted temporary PVC")
+	}
+	if c.TempDestPVC != nil && !slices.Contains(c.pvcsToDelete, c.TempDestPVC) && c.ctx.Err() != nil {
+		c.log.WithField("pvc-name", c.TempDestPVC.Name).Warning("Migration was interrupted, temporary PVC was kept as it might hold the only copy of the data")
+	}
+	return errors.Join(errs...)
+}
+
+// rollback is called when a stage after the deletion of the source PVC fails. If the source
+// PersistentVolume was retained, it is bound to a claim with the original name again. Otherwise
+// the temporary PVC holds the only copy of the data, so a claim with the original name is
+// recreated from it. The temporary PVC is only deleted once the data has been restored.
+func (c *CopyTwiceNameStrategy) rollback(sourcePVC *v1.PersistentVolumeClaim, cause error) error {
+	l := c.log.WithField("stage", "rollback").WithField("temp-pvc", c.TempDestPVC.Name)
+	l.Warning("Rolling back, restoring original PVC")
+	if c.ctx.Err() != nil {
+		// The migration was interrupted, but the rollback still has to happen. It may have to copy
+		// the data back, so it gets the time for that on top of the cleanup timeout. A second
+		// interrupt exits immediately.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.ctx), config.CleanupTimeout+2*c.timeout+c.MoveTimeout)
+		defer cancel()
+		c.ctx = ctx
+	}
+	c.pvcsToDelete = []*v1.PersistentVolumeClaim{}
+	fail := func(err error) error {
+		c.Cleanup()
+		return fmt.Errorf("%w, rollback failed: %w", cause, err)
+	}
+
+	if c.finalMover != nil {
+		// Make sure the failed job doesn't hold on to the temporary PVC anymore
+		err := c.finalMover.Cleanup()
 		if err != nil {
-			c.log.WithError(err).Warning("Error during temporary PVC cleanup, continuing")
+			l.WithError(err).Warning("failed to clean up mover job, it might still use the temporary PVC")
 		}
 	}
-	return nil
+
+	if c.DestPVC != nil {
+		l.WithField("pvc-name", c.DestPVC.Name).Debug("deleting incomplete destination PVC")
+		err := c.kClient.CoreV1().PersistentVolumeClaims(c.DestPVC.Namespace).Delete(c.ctx, c.DestPVC.Name, c.getDeleteOptions())
+		if err != nil && !apierrors.IsNotFound(err) {
+			l.WithError(err).Error("failed to delete incomplete destination PVC, data remains in temporary PVC")
+			return fail(err)
+		}
+		err = c.waitForPVCDeletion(c.DestPVC)
+		if err != nil {
+			l.WithError(err).Error("failed to delete incomplete destination PVC, data remains in temporary PVC")
+			return fail(err)
+		}
+		c.DestPVC = nil
+	}
+
+	restore := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sourcePVC.Name,
+			Namespace: sourcePVC.Namespace,
+			Labels:    sourcePVC.Labels,
+		},
+		Spec: *sourcePVC.Spec.DeepCopy(),
+	}
+
+	if c.RetainedPV != nil {
+		l.WithField("pv", c.RetainedPV.Name).Debug("rebinding retained source PersistentVolume")
+		_, err := c.rebindRetainedPV(c.RetainedPV.Name, restore)
+		if err != nil {
+			l.WithError(err).Error("failed to rebind retained PersistentVolume, data remains in temporary PVC")
+			return fail(err)
+		}
+		l.Info("Rebound original PersistentVolume, removing temporary PVC")
+		c.pvcsToDelete = []*v1.PersistentVolumeClaim{c.TempDestPVC}
+		c.Cleanup()
+		return fmt.Errorf("%w, rolled back to original PVC", cause)
+	}
+
+	l.Debug("recreating original PVC")
+	// The original volume is gone, let the provisioner create a new one
+	restore.Spec.VolumeName = ""
+	// Until the data is copied back, mark the PVC as created by this migration, so korb cleanup
+	// and resumed migrations know that the temporary PVC holds the only complete copy
+	restore.Labels = map[string]string{}
+	for k, v := range sourcePVC.Labels {
+		restore.Labels[k] = v
+	}
+	restore.Labels[config.LabelMigrationID] = c.migrationID
+	restoreInst, err := c.kClient.CoreV1().PersistentVolumeClaims(restore.Namespace).Create(c.ctx, restore, metav1.CreateOptions{})
+	if err != nil {
+		l.WithError(err).Error("failed to recreate original PVC, data remains in temporary PVC")
+		return fail(err)
+	}
+
+	c.rollbackMover = mover.NewMoverJob(c.ctx, c.kClient, mover.MoverTypeSync, c.tolerateAllNodes)
+	c.rollbackMover.Namespace = restoreInst.Namespace
+	c.rollbackMover.SourceVolume = c.TempDestPVC
+	c.rollbackMover.DestVolume = restoreInst
+	c.rollbackMover.Name = fmt.Sprintf("korb-job-%s", restoreInst.UID)
+	c.rollbackMover.MigrationID = c.migrationID
+	c.rollbackMover.RsyncOptions = c.rsyncOptions
+	err = c.rollbackMover.Start().Wait(c.timeout, c.MoveTimeout)
+	if err != nil {
+		l.WithError(err).Error("failed to copy data back to original PVC, data remains in temporary PVC")
+		return fail(err)
+	}
+
+	err = c.removeMigrationLabel(c.kClient, restoreInst)
+	if err != nil {
+		l.WithError(err).Error("failed to remove migration label from original PVC, data remains in temporary PVC")
+		return fail(err)
+	}
+
+	l.Info("Restored original PVC, removing temporary PVC")
+	c.pvcsToDelete = []*v1.PersistentVolumeClaim{c.TempDestPVC}
+	c.Cleanup()
+	return fmt.Errorf("%w, rolled back to original PVC", cause)
 }
 
 func (c *CopyTwiceNameStrategy) setTimeout(pvc *v1.PersistentVolumeClaim) {
@@ -172,7 +428,7 @@ func (c *CopyTwiceNameStrategy) setTimeout(pvc *v1.PersistentVolumeClaim) {
 func (c *CopyTwiceNameStrategy) waitForPVCDeletion(pvc *v1.PersistentVolumeClaim) error {
 	return wait.PollUntilContextTimeout(c.ctx, 2*time.Second, c.timeout, true, func(ctx context.Context) (bool, error) {
 		_, err := c.kClient.CoreV1().PersistentVolumeClaims(pvc.ObjectMeta.Namespace).Get(ctx, pvc.Name, metav1.GetOptions{})
-		if errors.IsNotFound(err) {
+		if apierrors.IsNotFound(err) {
 			return true, nil
 		}
 		c.log.WithField("pvc-name", pvc.ObjectMeta.Name).Debug("Waiting for PVC Deletion, retrying")
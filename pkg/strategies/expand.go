@@ -0,0 +1,309 @@
+// flag: expand
+// Behavior: Expand the PVC in place, when only the size grows and the storage class allows volume expansion.
+
+package strategies
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/kube"
+)
+
+// defaultResizeTimeout is used when no copy timeout is given, as resizing doesn't depend on the PVC size
+const defaultResizeTimeout = 10 * time.Minute
+
+type ExpandStrategy struct {
+	BaseStrategy
+
+	controllers []interface{}
+	// scaled holds the original replica count of all controllers scaled down for an offline resize
+	scaled map[interface{}]int32
+}
+
+func NewExpandStrategy(b BaseStrategy) *ExpandStrategy {
+	s := &ExpandStrategy{
+		BaseStrategy: b,
+		scaled:       map[interface{}]int32{},
+	}
+	s.log = s.log.WithField("strategy", s.Identifier())
+	return s
+}
+
+func (c *ExpandStrategy) Identifier() string {
+	return "expand"
+}
+
+func (c *ExpandStrategy) CompatibleWithContext(ctx MigrationContext) error {
+	source := ctx.SourcePVC
+	dest := ctx.DestTemplate
+	if c.sourceSubPath != "" || c.destSubPath != "" {
+		return errors.New("expand resizes the PVC in place, and can't copy subpaths")
+	}
+	if !c.ownership.Empty() {
+		return errors.New("expand doesn't copy data, and can't change its ownership")
+	}
+	if !c.rsyncOptions.Empty() {
+		return errors.New("expand doesn't copy data, and doesn't use rsync options")
+	}
+	if dest == nil {
+		return errors.New("no destination given")
+	}
+	if dest.Name != source.Name || dest.Namespace != source.Namespace {
+		return errors.New("expand can't rename PVCs")
+	}
+	if dest.Spec.StorageClassName != nil && (source.Spec.StorageClassName == nil || *dest.Spec.StorageClassName != *source.Spec.StorageClassName) {
+		return errors.New("expand can't change the storage class")
+	}
+	if len(dest.Spec.AccessModes) != len(source.Spec.AccessModes) {
+		return errors.New("expand can't change access modes")
+	}
+	for idx, mode := range dest.Spec.AccessModes {
+		if source.Spec.AccessModes[idx] != mode {
+			return errors.New("expand can't change access modes")
+		}
+	}
+	if dest.Spec.Resources.Requests.Storage().Cmp(*source.Spec.Resources.Requests.Storage()) != 1 {
+		return errors.New("expand requires a larger size")
+	}
+	sc := ctx.DestStorageClass
+	if sc == nil || sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return errors.New("storage class does not allow volume expansion")
+	}
+	if c.offlineResize {
+		if err := c.checkScalable(ctx); err != nil {
+			return err
+		}
+	}
+	c.controllers = ctx.PVCControllers
+	return nil
+}
+
+// checkScalable checks that all pods using the PVC are managed by a controller which can be scaled down
+// for an offline resize, so the resize doesn't wait for pods which never stop.
+func (c *ExpandStrategy) checkScalable(ctx MigrationContext) error {
+	for _, controller := range ctx.PVCControllers {
+		switch controller.(type) {
+		case *appsv1.Deployment, *appsv1.StatefulSet:
+		default:
+			return fmt.Errorf("offline resize can only scale down deployments and statefulsets, PVC is used by a %T", controller)
+		}
+	}
+	for _, pod := range ctx.PVCPods {
+		scalable := false
+		for _, owner := range pod.OwnerReferences {
+			// Pods of deployments are owned by their ReplicaSet
+			if owner.Kind == "ReplicaSet" || owner.Kind == "StatefulSet" {
+				scalable = true
+			}
+		}
+		if !scalable {
+			return fmt.Errorf("offline resize can't stop pod %s, which is not managed by a deployment or statefulset", pod.Name)
+		}
+	}
+	return nil
+}
+
+func (c *ExpandStrategy) Description() string {
+	return "Expand the PVC in place, when only the size grows and the storage class allows volume expansion."
+}
+
+// SafeForLiveData is true, as the data is not copied. For offline resizes, the strategy
+// scales down all controllers by itself, and is only compatible if all pods can be stopped that way.
+func (c *ExpandStrategy) SafeForLiveData() bool {
+	return true
+}
+
+func (c *ExpandStrategy) SkipCapacityCheck() bool {
+	return true
+}
+
+func (c *ExpandStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim, WaitForTempDestPVCBind bool) error {
+	size := *destTemplate.Spec.Resources.Requests.Storage()
+	timeout := defaultResizeTimeout
+	if c.copyTimeout != nil {
+		timeout = *c.copyTimeout
+	}
+
+	if c.offlineResize {
+		c.stage(1, "scaling down controllers")
+		err := c.scaleDown()
+		if err != nil {
+			c.log.WithError(err).Warning("failed to scale down controllers")
+			return errors.Join(err, c.Cleanup())
+		}
+		err = c.waitForNoPods(sourcePVC)
+		if err != nil {
+			c.log.WithError(err).Warning("pods using the PVC did not stop")
+			return errors.Join(err, c.Cleanup())
+		}
+	}
+
+	c.stage(2, "requesting new size "+size.String())
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"resources": map[string]interface{}{
+				"requests": map[string]string{
+					string(v1.ResourceStorage): size.String(),
+				},
+			},
+		},
+	})
+	_, err := c.kClient.CoreV1().PersistentVolumeClaims(sourcePVC.Namespace).Patch(c.ctx, sourcePVC.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		c.log.WithError(err).Warning("failed to patch PVC")
+		return errors.Join(err, c.Cleanup())
+	}
+
+	// The filesystem is only resized once a pod mounts the volume, so when no pod is
+	// using it (or all were scaled down) only wait for the volume itself to be resized
+	c.stage(3, "waiting for volume to be resized")
+	err = c.waitForResize(sourcePVC, size, timeout, c.offlineResize || len(c.controllers) == 0)
+	if err != nil {
+		c.log.WithError(err).Warning("volume was not resized")
+		return errors.Join(err, c.Cleanup())
+	}
+
+	if c.offlineResize {
+		c.stage(4, "scaling up controllers")
+		scaledUp := false
+		for _, replicas := range c.scaled {
+			scaledUp = scaledUp || replicas > 0
+		}
+		err = c.Cleanup()
+		if err != nil {
+			return err
+		}
+		if !scaledUp {
+			c.log.Info("And we're done, filesystem will be resized when the volume is mounted")
+			return nil
+		}
+		c.stage(5, "waiting for filesystem to be resized")
+		err = c.waitForResize(sourcePVC, size, timeout, false)
+		if err != nil {
+			c.log.WithError(err).Warning("filesystem was not resized")
+			return err
+		}
+	}
+	c.log.Info("And we're done")
+	return nil
+}
+
+// waitForResize waits until the capacity of the PVC reaches size. If allowPending is true, a PVC which
+// waits for its filesystem to be resized on the next mount is also considered resized.
+func (c *ExpandStrategy) waitForResize(p *v1.PersistentVolumeClaim, size resource.Quantity, timeout time.Duration, allowPending bool) error {
+	return wait.PollUntilContextTimeout(c.ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := c.kClient.CoreV1().PersistentVolumeClaims(p.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if pvc.Status.Capacity.Storage().Cmp(size) >= 0 {
+			return true, nil
+		}
+		for _, cond := range pvc.Status.Conditions {
+			if cond.Type == v1.PersistentVolumeClaimFileSystemResizePending && cond.Status == v1.ConditionTrue {
+				if allowPending {
+					return true, nil
+				}
+				// The filesystem is only resized when a pod mounts the volume
+				c.log.WithField("pvc-name", pvc.Name).Info("Filesystem resize pending until the volume is mounted by a pod, retrying")
+				return false, nil
+			}
+		}
+		c.log.WithField("pvc-name", pvc.Name).WithField("capacity", pvc.Status.Capacity.Storage().String()).Debug("PVC not resized yet, retrying")
+		return false, nil
+	})
+}
+
+func (c *ExpandStrategy) scaleDown() error {
+	for _, controller := range c.controllers {
+		replicas, err := c.scale(c.ctx, controller, 0)
+		if err != nil {
+			return err
+		}
+		c.scaled[controller] = replicas
+	}
+	return nil
+}
+
+// scale sets the replicas of a deployment or statefulset, and returns the previous replica count
+func (c *ExpandStrategy) scale(ctx context.Context, controller interface{}, replicas int32) (int32, error) {
+	var scale *autoscalingv1.Scale
+	var update func(*autoscalingv1.Scale) error
+	var err error
+	switch ctrl := controller.(type) {
+	case *appsv1.Deployment:
+		client := c.kClient.AppsV1().Deployments(ctrl.Namespace)
+		scale, err = client.GetScale(ctx, ctrl.Name, metav1.GetOptions{})
+		update = func(s *autoscalingv1.Scale) error {
+			_, err := client.UpdateScale(ctx, ctrl.Name, s, metav1.UpdateOptions{})
+			return err
+		}
+	case *appsv1.StatefulSet:
+		client := c.kClient.AppsV1().StatefulSets(ctrl.Namespace)
+		scale, err = client.GetScale(ctx, ctrl.Name, metav1.GetOptions{})
+		update = func(s *autoscalingv1.Scale) error {
+			_, err := client.UpdateScale(ctx, ctrl.Name, s, metav1.UpdateOptions{})
+			return err
+		}
+	default:
+		return 0, fmt.Errorf("can't scale %T", controller)
+	}
+	if err != nil {
+		return 0, err
+	}
+	previous := scale.Spec.Replicas
+	scale.Spec.Replicas = replicas
+	err = update(scale)
+	if err != nil {
+		return 0, err
+	}
+	c.log.WithField("name", scale.Name).WithField("replicas", replicas).Info("Scaled controller")
+	return previous, nil
+}
+
+func (c *ExpandStrategy) waitForNoPods(pvc *v1.PersistentVolumeClaim) error {
+	return wait.PollUntilContextTimeout(c.ctx, 2*time.Second, c.timeout, true, func(ctx context.Context) (bool, error) {
+		pods, err := c.kClient.CoreV1().Pods(pvc.Namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, pod := range pods.Items {
+			if kube.UsesPVC(pod, pvc.Name) {
+				c.log.WithField("pod", pod.Name).Debug("Pod still using PVC, retrying")
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// Cleanup scales all controllers which were scaled down back to their original replica count
+func (c *ExpandStrategy) Cleanup() error {
+	c.log.Info("Cleaning up...")
+	ctx, cancel := config.CleanupContext(c.ctx)
+	defer cancel()
+	errs := []error{}
+	for controller, replicas := range c.scaled {
+		_, err := c.scale(ctx, controller, replicas)
+		if err != nil {
+			c.log.WithError(err).Warning("failed to scale up controller")
+			errs = append(errs, err)
+			continue
+		}
+		delete(c.scaled, controller)
+	}
+	return errors.Join(errs...)
+}
@@ -1,17 +1,20 @@
 // flag: export
-// Behavior: Exports a tar archive of the pvc to your $pwd
+// Behavior: Exports a tar archive of the pvc to your $pwd, or the path given with --archive.
+// A manifest with the size and SHA-256 of the archive is written next to it. With --split-size,
+// the archive is split into numbered chunks.
 
 package strategies
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/schollz/progressbar/v3"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
 
+	"beryju.org/korb/v2/pkg/archive"
 	"beryju.org/korb/v2/pkg/mover"
 )
 
@@ -21,6 +24,7 @@ type ExportStrategy struct {
 	TempDestPVC *v1.PersistentVolumeClaim
 
 	tempMover *mover.MoverJob
+	source    *archive.Source
 }
 
 func NewExportStrategy(b BaseStrategy) *ExportStrategy {
@@ -36,9 +40,22 @@ func (c *ExportStrategy) Identifier() string {
 }
 
 func (c *ExportStrategy) CompatibleWithContext(ctx MigrationContext) error {
+	if c.destSubPath != "" {
+		return errors.New("export doesn't support a destination subpath")
+	}
+	if !c.ownership.Empty() {
+		return errors.New("export keeps the ownership in the archive, change it when importing instead")
+	}
+	if !c.rsyncOptions.Empty() {
+		return errors.New("export writes a tar archive, and doesn't use rsync options")
+	}
 	return nil
 }
 
+func (c *ExportStrategy) SkipCapacityCheck() bool {
+	return true
+}
+
 func (c *ExportStrategy) Description() string {
 	return "Export PVC content into a tar archive."
 }
@@ -51,45 +68,80 @@ func (c *ExportStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v
 	c.tempMover.Namespace = destTemplate.ObjectMeta.Namespace
 	c.tempMover.SourceVolume = sourcePVC
 	c.tempMover.Name = fmt.Sprintf("korb-job-%s", sourcePVC.UID)
+	c.tempMover.MigrationID = c.migrationID
+	c.tempMover.SourceSubPath = c.sourceSubPath
 
 	pod := c.tempMover.Start().WaitForRunning(c.timeout)
 	if pod == nil {
 		c.log.Warning("Failed to move data")
-		return c.Cleanup()
+		return errors.Join(errors.New("mover pod not in correct state"), c.Cleanup())
 	}
 	c.log.Debug("mover pod running, starting copy")
 
-	output, err := c.CopyOut(*pod, c.kConfig, sourcePVC.Name)
+	// Stored in the manifest, so the import strategy can recreate the PVC
+	c.source = &archive.Source{
+		Namespace:   sourcePVC.Namespace,
+		Name:        sourcePVC.Name,
+		Size:        sourcePVC.Spec.Resources.Requests.Storage().String(),
+		AccessModes: make([]string, 0, len(sourcePVC.Spec.AccessModes)),
+	}
+	if sourcePVC.Spec.StorageClassName != nil {
+		c.source.StorageClass = *sourcePVC.Spec.StorageClassName
+	}
+	for _, mode := range sourcePVC.Spec.AccessModes {
+		c.source.AccessModes = append(c.source.AccessModes, string(mode))
+	}
+
+	output, err := c.CopyOut(*pod, c.kConfig, c.ArchivePath(sourcePVC.Name))
 	if err != nil {
 		c.log.WithError(err).Warning("failed to copy file")
-		return c.Cleanup()
+		return errors.Join(err, c.Cleanup())
 	}
 	c.log.Info("Finished copying")
-	c.log.Infof("Export at '%s'", output)
+	c.log.WithField("manifest", archive.ManifestPath(output)).Infof("Export at '%s'", output)
 	return c.Cleanup()
 }
 
-func (c *ExportStrategy) CopyOut(pod v1.Pod, config *rest.Config, name string) (string, error) {
-	file, err := os.CreateTemp(".", "korb-mover-")
+func (c *ExportStrategy) CopyOut(pod v1.Pod, config *rest.Config, finalPath string) (string, error) {
+	file, err := archive.NewFileWriter(finalPath, c.splitSize)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	file.Source = c.source
+	var out io.Writer = file
+	var enc io.WriteCloser
+	if c.encryption != nil {
+		// Encrypt the stream before it is written, so no plaintext is stored locally
+		enc, err = archive.NewWriter(file, c.encryption)
+		if err != nil {
+			file.Abort()
+			return "", err
+		}
+		out = enc
+	}
 	bar := progressbar.DefaultBytes(
 		-1,
 		"downloading",
 	)
-	cmd := []string{
-		"bash",
-		"-c",
-		fmt.Sprintf("cd \"%s\" && tar cvzf - . ; sleep 5", mover.SourceMount),
+	cmd, err := c.tempMover.TarCreate(pod, config, mover.SourceMount, "cvzf", c.filter)
+	if err != nil {
+		file.Abort()
+		return "", err
+	}
+	// The exit code of tar is returned as error, so partial archives aren't kept
+	err = c.tempMover.Exec(pod, config, cmd, nil, io.MultiWriter(out, bar))
+	if err != nil {
+		err = fmt.Errorf("tar failed: %w", err)
+	}
+	if err == nil && enc != nil {
+		err = enc.Close()
 	}
-	err = c.tempMover.Exec(pod, config, cmd, nil, io.MultiWriter(file, bar))
 	if err != nil {
+		// Don't leave a partial archive behind
+		file.Abort()
 		return "", err
 	}
-	finalPath := fmt.Sprintf("%s.tar", name)
-	if err = os.Rename(file.Name(), finalPath); err != nil {
+	if err = file.Close(); err != nil {
 		return "", err
 	}
 	return finalPath, nil
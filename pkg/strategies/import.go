@@ -1,23 +1,52 @@
 // flag: import
-// Behavior: Imports a tar archive into pvc
+// Behavior: Imports a tar archive into pvc, which is created if it doesn't exist. The archive is
+// verified against its manifest before anything is extracted, split archives are read chunk by chunk.
+// --import-mode decides whether existing data is kept, replaced, or whether the volume must be empty.
 
 package strategies
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 
+	"beryju.org/korb/v2/pkg/archive"
+	"beryju.org/korb/v2/pkg/config"
 	"beryju.org/korb/v2/pkg/mover"
 )
 
+// Import modes decide what happens to data which is already on the volume
+const (
+	// ImportModeMerge extracts the archive on top of the existing data
+	ImportModeMerge = "merge"
+	// ImportModeReplace deletes everything on the volume except lost+found before extracting
+	ImportModeReplace = "replace"
+	// ImportModeEmptyOnly refuses to import into a volume which contains anything except lost+found
+	ImportModeEmptyOnly = "empty-only"
+)
+
+// ImportModes are all supported import modes
+var ImportModes = []string{ImportModeMerge, ImportModeReplace, ImportModeEmptyOnly}
+
 type ImportStrategy struct {
 	BaseStrategy
 
 	TempDestPVC *v1.PersistentVolumeClaim
+	// CreatedPVC is set if the PVC didn't exist and was created by this strategy
+	CreatedPVC *v1.PersistentVolumeClaim
 
 	tempMover *mover.MoverJob
 }
@@ -35,14 +64,30 @@ func (c *ImportStrategy) Identifier() string {
 }
 
 func (c *ImportStrategy) CompatibleWithContext(ctx MigrationContext) error {
-	path := fmt.Sprintf("%s.tar", ctx.SourcePVC.Name)
-	_, err := os.Stat(path)
-	if errors.Is(err, os.ErrNotExist) {
+	if c.sourceSubPath != "" {
+		return errors.New("import doesn't support a source subpath, use a destination subpath to import into a directory")
+	}
+	if !c.rsyncOptions.Empty() {
+		return errors.New("import extracts a tar archive, and doesn't use rsync options")
+	}
+	if !slices.Contains(ImportModes, c.ImportMode()) {
+		return fmt.Errorf("unknown import mode '%s', expected one of %s", c.ImportMode(), strings.Join(ImportModes, ", "))
+	}
+	path := c.ArchivePath(ctx.SourcePVC.Name)
+	if !archive.Exists(path) {
 		return fmt.Errorf("expected import file '%s' does not exist", path)
 	}
 	return nil
 }
 
+func (c *ImportStrategy) SkipCapacityCheck() bool {
+	return true
+}
+
+func (c *ImportStrategy) CreatesMissingSource() bool {
+	return true
+}
+
 func (c *ImportStrategy) Description() string {
 	return "Import data into a PVC from a tar archive."
 }
@@ -50,43 +95,252 @@ func (c *ImportStrategy) Description() string {
 func (c *ImportStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim, WaitForTempDestPVCBind bool) error {
 	c.log.Warning("This strategy assumes you've stopped all pods accessing this data.")
 
+	// Check the key before anything is started, so a wrong key fails early
+	err := c.checkArchive(c.ArchivePath(sourcePVC.Name))
+	if err != nil {
+		return err
+	}
+
+	c.summary(sourcePVC)
+
+	if sourcePVC.UID == "" {
+		sourcePVC, err = c.createPVC(sourcePVC, destTemplate, WaitForTempDestPVCBind)
+		if err != nil {
+			return err
+		}
+	}
+
 	c.log.Debug("starting mover job")
 	c.tempMover = mover.NewMoverJob(c.ctx, c.kClient, mover.MoverTypeSleep, c.tolerateAllNodes)
 	c.tempMover.Namespace = destTemplate.ObjectMeta.Namespace
 	c.tempMover.SourceVolume = sourcePVC
 	c.tempMover.Name = fmt.Sprintf("korb-job-%s", sourcePVC.UID)
+	c.tempMover.MigrationID = c.migrationID
+	// The PVC which is imported into is the destination of the data
+	c.tempMover.SourceSubPath = c.destSubPath
 
 	pod := c.tempMover.Start().WaitForRunning(c.timeout)
 	if pod == nil {
 		c.log.Warning("Failed to move data")
-		return c.Cleanup()
+		return errors.Join(errors.New("mover pod not in correct state"), c.Cleanup(), c.cleanupCreatedPVC())
+	}
+	c.log.Debug("mover pod running, preparing volume")
+
+	err = c.prepareVolume(*pod, c.kConfig)
+	if err != nil {
+		c.log.WithError(err).Warning("failed to prepare volume")
+		return errors.Join(err, c.Cleanup(), c.cleanupCreatedPVC())
 	}
-	c.log.Debug("mover pod running, starting copy")
+	c.log.Debug("starting copy")
 
-	err := c.CopyInto(*pod, c.kConfig, fmt.Sprintf("%s.tar", sourcePVC.Name))
+	err = c.CopyInto(*pod, c.kConfig, c.ArchivePath(sourcePVC.Name))
 	if err != nil {
 		c.log.WithError(err).Warning("failed to copy file")
-		return c.Cleanup()
+		return errors.Join(err, c.Cleanup(), c.cleanupCreatedPVC())
 	}
 	c.log.Info("Finished copying into pvc")
 	return c.Cleanup()
 }
 
 func (c *ImportStrategy) CopyInto(pod v1.Pod, config *rest.Config, localPath string) error {
-	file, err := os.Open(localPath)
+	file, err := archive.Open(localPath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	in, err := c.decrypt(file)
+	if err != nil {
+		return err
+	}
+	flags := "xvzf"
+	if !c.filter.Empty() {
+		// Filtered by korb, so the patterns match the same paths as for sync movers and exports
+		filtered := archive.FilterTar(in, c.filter.Match)
+		defer filtered.Close()
+		in = filtered
+		flags = "xvf"
+	}
+	cmd := append([]string{
+		"bash",
+		"-c",
+		fmt.Sprintf(`cd "%s" && tar %s - "$@"`, mover.SourceMount, flags),
+		"bash",
+	}, c.ownership.TarArgs()...)
+	err = c.tempMover.Exec(pod, config, cmd, in, os.Stdout)
+	if err != nil {
+		return err
+	}
+	return c.tempMover.ApplyOwnership(pod, config, mover.SourceMount, c.ownership)
+}
+
+// ListPaths lists the top-level paths in the archive.
+func (c *ImportStrategy) ListPaths(sourcePVC *v1.PersistentVolumeClaim) ([]string, error) {
+	path := c.ArchivePath(sourcePVC.Name)
+	if err := c.checkArchive(path); err != nil {
+		return nil, err
+	}
+	file, err := archive.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	in, err := c.decrypt(file)
+	if err != nil {
+		return nil, err
+	}
+	return archive.TopLevel(in)
+}
+
+// summary logs what is about to be imported, before anything is changed.
+func (c *ImportStrategy) summary(sourcePVC *v1.PersistentVolumeClaim) {
+	path := c.ArchivePath(sourcePVC.Name)
+	l := c.log.WithField("archive", path).WithField("pvc", sourcePVC.Name).WithField("import-mode", c.ImportMode())
+	if m, err := archive.ReadManifest(path); err == nil {
+		l = l.WithField("size", m.Size).WithField("chunks", len(m.Chunks))
+		if m.Source != nil {
+			l = l.WithField("exported-from", fmt.Sprintf("%s/%s", m.Source.Namespace, m.Source.Name))
+		}
+	} else if info, err := os.Stat(path); err == nil {
+		l = l.WithField("size", info.Size())
+	}
+	l = l.WithField("encrypted", c.encryption != nil).WithField("create-pvc", sourcePVC.UID == "")
+	l.Info("Importing archive")
+}
+
+// prepareVolume handles existing data on the volume according to the import mode.
+func (c *ImportStrategy) prepareVolume(pod v1.Pod, config *rest.Config) error {
+	// lost+found is created by some filesystems, and must not be removed
+	existing := "find . -mindepth 1 -maxdepth 1 ! -name lost+found"
+	var script string
+	switch c.ImportMode() {
+	case ImportModeReplace:
+		script = existing + " -exec rm -rf {} +"
+	case ImportModeEmptyOnly:
+		script = existing + " | head -n 5"
+	default:
+		return nil
+	}
 	cmd := []string{
 		"bash",
 		"-c",
-		fmt.Sprintf("cd \"%s\" && tar xvzf -", mover.SourceMount),
+		fmt.Sprintf("cd \"%s\" && %s", mover.SourceMount, script),
+	}
+	out := &bytes.Buffer{}
+	err := c.tempMover.Exec(pod, config, cmd, nil, out)
+	if err != nil {
+		return err
+	}
+	if c.ImportMode() == ImportModeReplace {
+		c.log.Info("Removed existing data from volume")
+		return nil
+	}
+	if found := strings.TrimSpace(out.String()); found != "" {
+		return fmt.Errorf("volume is not empty (found %s), refusing to import with import mode %s", strings.ReplaceAll(found, "\n", ", "), ImportModeEmptyOnly)
+	}
+	return nil
+}
+
+// decrypt returns a reader for the plaintext of the archive, which is decrypted transparently
+// if it is encrypted.
+func (c *ImportStrategy) decrypt(file io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(file)
+	encrypted, err := archive.IsEncrypted(br)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return br, nil
+	}
+	return archive.NewReader(br, c.encryption)
+}
+
+// checkArchive verifies the archive against its manifest, and checks that it can be decrypted with the given key.
+func (c *ImportStrategy) checkArchive(localPath string) error {
+	// Check the archive against its manifest before anything is extracted
+	err := archive.Verify(localPath)
+	if errors.Is(err, archive.ErrNoManifest) {
+		c.log.Warning("Archive has no manifest, it can't be verified before importing")
+	} else if err != nil {
+		return fmt.Errorf("failed to verify archive: %w", err)
 	}
-	err = c.tempMover.Exec(pod, config, cmd, file, os.Stdout)
+	file, err := archive.Open(localPath)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	encrypted, err := archive.IsEncrypted(br)
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		if c.encryption != nil {
+			c.log.Warning("Archive is not encrypted, ignoring encryption key")
+		}
+		return nil
+	}
+	_, err = archive.NewReader(br, c.encryption)
+	return err
+}
+
+// createPVC creates the PVC to import into, with the size, storage class and access modes which
+// were resolved from the destination options or the manifest of the archive.
+func (c *ImportStrategy) createPVC(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim, waitForBind bool) (*v1.PersistentVolumeClaim, error) {
+	// The PVC is the final result of the import, so it isn't marked as part of the migration
+	labels := map[string]string{}
+	for k, v := range destTemplate.Labels {
+		labels[k] = v
+	}
+	delete(labels, config.LabelMigrationID)
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sourcePVC.Name,
+			Namespace: destTemplate.Namespace,
+			Labels:    labels,
+		},
+		Spec: *sourcePVC.Spec.DeepCopy(),
+	}
+	l := c.log.WithField("pvc-name", pvc.Name).WithField("size", pvc.Spec.Resources.Requests.Storage().String())
+	l.Info("PVC does not exist, creating it")
+	created, err := c.kClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(c.ctx, pvc, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PVC: %w", err)
+	}
+	c.CreatedPVC = created
+	if !waitForBind {
+		l.Debug("skipping waiting for PVC to be bound")
+		return created, nil
+	}
+	err = wait.PollUntilContextTimeout(c.ctx, 2*time.Second, c.timeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := c.kClient.CoreV1().PersistentVolumeClaims(created.Namespace).Get(ctx, created.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if pvc.Status.Phase != v1.ClaimBound {
+			l.Warning("PVC not bound yet, retrying")
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("PVC was not bound: %w", err), c.cleanupCreatedPVC())
+	}
+	return created, nil
+}
+
+// cleanupCreatedPVC removes the PVC after a failed import, if it was created by this strategy.
+func (c *ImportStrategy) cleanupCreatedPVC() error {
+	if c.CreatedPVC == nil {
+		return nil
+	}
+	ctx, cancel := config.CleanupContext(c.ctx)
+	defer cancel()
+	err := c.kClient.CoreV1().PersistentVolumeClaims(c.CreatedPVC.Namespace).Delete(ctx, c.CreatedPVC.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		c.log.WithError(err).WithField("pvc-name", c.CreatedPVC.Name).Warning("failed to delete created PVC")
+		return err
+	}
+	c.log.WithField("pvc-name", c.CreatedPVC.Name).Info("Deleted created PVC")
 	return nil
 }
 
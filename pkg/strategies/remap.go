@@ -0,0 +1,226 @@
+// flag: remap
+// Behavior: Copy directories of one or more PVCs into directories of one or more other PVCs, as given by the mappings, in a single mover job.
+
+package strategies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/mover"
+)
+
+type RemapStrategy struct {
+	BaseStrategy
+
+	// CreatedPVCs are the destination PVCs which didn't exist before, and are removed if the copy fails
+	CreatedPVCs []*v1.PersistentVolumeClaim
+
+	moverJob    *mover.MoverJob
+	moveTimeout time.Duration
+}
+
+func NewRemapStrategy(b BaseStrategy) *RemapStrategy {
+	s := &RemapStrategy{
+		BaseStrategy: b,
+		CreatedPVCs:  make([]*v1.PersistentVolumeClaim, 0),
+	}
+	s.log = s.log.WithField("strategy", s.Identifier())
+	return s
+}
+
+func (c *RemapStrategy) Identifier() string {
+	return "remap"
+}
+
+func (c *RemapStrategy) CompatibleWithContext(ctx MigrationContext) error {
+	if len(c.mappings) == 0 {
+		return errors.New("no mappings given")
+	}
+	if c.sourceSubPath != "" || c.destSubPath != "" {
+		return errors.New("remap takes subpaths from the mappings, and can't be used with subpath options")
+	}
+	if !slices.Contains(mover.SourcePVCs(c.mappings), ctx.SourcePVC.Name) {
+		return fmt.Errorf("PVC %s is not a source of the mappings", ctx.SourcePVC.Name)
+	}
+	if ctx.DestTemplate != nil && ctx.DestTemplate.Namespace != ctx.SourcePVC.Namespace {
+		return errors.New("remap mounts all PVCs in one pod, so they must be in the same namespace")
+	}
+	return nil
+}
+
+// SkipCapacityCheck is true as the sizes of destination PVCs are derived from all sources mapped into them.
+func (c *RemapStrategy) SkipCapacityCheck() bool {
+	return true
+}
+
+// SupportsMappings is true as the strategy copies all mappings, instead of a single source PVC.
+func (c *RemapStrategy) SupportsMappings() bool {
+	return true
+}
+
+func (c *RemapStrategy) Description() string {
+	return "Copy directories of one or more PVCs into directories of one or more other PVCs, in a single mover job."
+}
+
+func (c *RemapStrategy) Do(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim, WaitForTempDestPVCBind bool) error {
+	c.stage(1, "getting source PVCs")
+	sources := map[string]*v1.PersistentVolumeClaim{}
+	for _, name := range mover.SourcePVCs(c.mappings) {
+		pvc, err := c.kClient.CoreV1().PersistentVolumeClaims(sourcePVC.Namespace).Get(c.ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get source PVC %s: %w", name, err)
+		}
+		sources[name] = pvc
+	}
+
+	c.stage(2, "creating destination PVCs")
+	total := resource.Quantity{}
+	for _, name := range mover.DestPVCs(c.mappings) {
+		size := c.destSize(name, sources, destTemplate)
+		total.Add(size)
+		err := c.ensureDestPVC(name, size, destTemplate, WaitForTempDestPVCBind)
+		if err != nil {
+			c.log.WithError(err).WithField("pvc-name", name).Warning("Failed to create destination PVC")
+			return errors.Join(err, c.cleanupCreatedPVCs())
+		}
+	}
+	c.setTimeout(total)
+
+	c.stage(3, "starting mover job")
+	c.moverJob = mover.NewMoverJob(c.ctx, c.kClient, mover.MoverTypeSyncMap, c.tolerateAllNodes)
+	c.moverJob.Namespace = sourcePVC.Namespace
+	c.moverJob.Name = fmt.Sprintf("korb-job-%s", sourcePVC.UID)
+	c.moverJob.MigrationID = c.migrationID
+	c.moverJob.Mappings = c.mappings
+	c.moverJob.RsyncOptions = c.rsyncOptions
+	c.moverJob.Filter = c.filter
+	c.moverJob.Ownership = c.ownership
+	err := c.moverJob.Start().Wait(c.timeout, c.moveTimeout)
+	if err != nil {
+		c.log.WithError(err).Warning("Failed to move data")
+		return errors.Join(err, c.Cleanup(), c.cleanupCreatedPVCs())
+	}
+	for _, pvc := range c.CreatedPVCs {
+		err = c.removeMigrationLabel(c.kClient, pvc)
+		if err != nil {
+			c.log.WithError(err).WithField("pvc-name", pvc.Name).Warning("failed to remove migration label from destination PVC")
+		}
+	}
+	c.log.Info("And we're done")
+	return c.Cleanup()
+}
+
+// destSize returns the size of a new destination PVC, which is the size of the destination template,
+// or the sum of all source PVCs mapped into it if that is larger.
+func (c *RemapStrategy) destSize(dest string, sources map[string]*v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim) resource.Quantity {
+	sum := resource.Quantity{}
+	counted := map[string]struct{}{}
+	for _, m := range c.mappings {
+		if m.Dest.PVC != dest {
+			continue
+		}
+		if _, ok := counted[m.Source.PVC]; ok {
+			continue
+		}
+		counted[m.Source.PVC] = struct{}{}
+		sum.Add(*sources[m.Source.PVC].Spec.Resources.Requests.Storage())
+	}
+	size := destTemplate.Spec.Resources.Requests.Storage().DeepCopy()
+	if sum.Cmp(size) == 1 {
+		return sum
+	}
+	return size
+}
+
+// ensureDestPVC creates the destination PVC with the spec of the destination template, unless it already exists.
+func (c *RemapStrategy) ensureDestPVC(name string, size resource.Quantity, destTemplate *v1.PersistentVolumeClaim, waitForBind bool) error {
+	l := c.log.WithField("pvc-name", name)
+	_, err := c.kClient.CoreV1().PersistentVolumeClaims(destTemplate.Namespace).Get(c.ctx, name, metav1.GetOptions{})
+	if err == nil {
+		l.Info("Destination PVC exists, copying into it")
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: destTemplate.Namespace,
+			Labels: map[string]string{
+				config.LabelMigrationID: c.migrationID,
+			},
+		},
+		Spec: *destTemplate.Spec.DeepCopy(),
+	}
+	pvc.Spec.Resources.Requests[v1.ResourceStorage] = size
+	l.WithField("size", size.String()).Info("Creating destination PVC")
+	created, err := c.kClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(c.ctx, pvc, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	c.CreatedPVCs = append(c.CreatedPVCs, created)
+	if !waitForBind {
+		l.Debug("skipping waiting for PVC to be bound")
+		return nil
+	}
+	return wait.PollUntilContextTimeout(c.ctx, 2*time.Second, c.timeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := c.kClient.CoreV1().PersistentVolumeClaims(created.Namespace).Get(ctx, created.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if pvc.Status.Phase != v1.ClaimBound {
+			l.Warning("PVC not bound yet, retrying")
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+func (c *RemapStrategy) setTimeout(total resource.Quantity) {
+	if c.copyTimeout != nil {
+		c.moveTimeout = *c.copyTimeout
+	} else {
+		sizeInMB := float64(total.Value()) / 1024 / 1024
+		c.moveTimeout = time.Duration(sizeInMB*(60.0/1024)) * time.Second
+	}
+	c.log.WithField("timeout", c.moveTimeout).Debug("Set timeout from PVC sizes")
+}
+
+// cleanupCreatedPVCs removes the destination PVCs created by this migration after a failed copy.
+// Source PVCs and destination PVCs which existed before are never removed.
+func (c *RemapStrategy) cleanupCreatedPVCs() error {
+	ctx, cancel := config.CleanupContext(c.ctx)
+	defer cancel()
+	errs := []error{}
+	for _, pvc := range c.CreatedPVCs {
+		l := c.log.WithField("pvc-name", pvc.Name)
+		err := c.kClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Delete(ctx, pvc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			l.WithError(err).Warning("failed to delete incomplete destination PVC")
+			errs = append(errs, err)
+			continue
+		}
+		l.Info("Deleted incomplete destination PVC")
+	}
+	return errors.Join(errs...)
+}
+
+func (c *RemapStrategy) Cleanup() error {
+	c.log.Info("Cleaning up...")
+	if c.moverJob != nil {
+		return c.moverJob.Cleanup()
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package strategies
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"beryju.org/korb/v2/pkg/config"
+)
+
+// retainPV sets the reclaim policy of the PersistentVolume bound to pvc to Retain,
+// so that deleting the PVC doesn't destroy the data. The volume is labelled with the migration ID
+// so it can be found by `korb prune-retained` later.
+func (b *BaseStrategy) retainPV(pvc *v1.PersistentVolumeClaim) (*v1.PersistentVolume, error) {
+	if pvc.Spec.VolumeName == "" {
+		b.log.Debug("source PVC is not bound, nothing to retain")
+		return nil, nil
+	}
+	pv, err := b.kClient.CoreV1().PersistentVolumes().Get(b.ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if pv.Labels == nil {
+		pv.Labels = map[string]string{}
+	}
+	if pv.Annotations == nil {
+		pv.Annotations = map[string]string{}
+	}
+	if pv.Labels[config.LabelMigrationID] == b.migrationID {
+		// Already retained by an earlier attempt of this migration, keep the original policy
+		return pv, nil
+	}
+	pv.Labels[config.LabelMigrationID] = b.migrationID
+	pv.Annotations[config.AnnotationRetainedAt] = time.Now().UTC().Format(time.RFC3339)
+	pv.Annotations[config.AnnotationOriginalReclaimPolicy] = string(pv.Spec.PersistentVolumeReclaimPolicy)
+	pv.Annotations[config.AnnotationSourcePVC] = pvc.Namespace + "/" + pvc.Name
+	pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimRetain
+	pv, err = b.kClient.CoreV1().PersistentVolumes().Update(b.ctx, pv, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	b.log.WithField("pv", pv.Name).Info("Retaining source PersistentVolume")
+	return pv, nil
+}
+
+// rebindRetainedPV binds a PersistentVolume retained by retainPV to a new claim created from claim,
+// and restores the reclaim policy the volume had originally.
+func (b *BaseStrategy) rebindRetainedPV(pvName string, claim *v1.PersistentVolumeClaim) (*v1.PersistentVolumeClaim, error) {
+	pv, err := b.kClient.CoreV1().PersistentVolumes().Get(b.ctx, pvName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// Pre-bind the volume to the new claim, which doesn't have a UID yet
+	pv.Spec.ClaimRef = &v1.ObjectReference{
+		Kind:       "PersistentVolumeClaim",
+		APIVersion: "v1",
+		Namespace:  claim.Namespace,
+		Name:       claim.Name,
+	}
+	if policy, ok := pv.Annotations[config.AnnotationOriginalReclaimPolicy]; ok {
+		pv.Spec.PersistentVolumeReclaimPolicy = v1.PersistentVolumeReclaimPolicy(policy)
+	}
+	delete(pv.Labels, config.LabelMigrationID)
+	delete(pv.Annotations, config.AnnotationRetainedAt)
+	delete(pv.Annotations, config.AnnotationOriginalReclaimPolicy)
+	delete(pv.Annotations, config.AnnotationSourcePVC)
+	_, err = b.kClient.CoreV1().PersistentVolumes().Update(b.ctx, pv, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	claim = claim.DeepCopy()
+	claim.Spec.VolumeName = pvName
+	inst, err := b.kClient.CoreV1().PersistentVolumeClaims(claim.Namespace).Create(b.ctx, claim, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	err = wait.PollUntilContextTimeout(b.ctx, 2*time.Second, b.timeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := b.kClient.CoreV1().PersistentVolumeClaims(inst.Namespace).Get(ctx, inst.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return pvc.Status.Phase == v1.ClaimBound, nil
+	})
+	return inst, err
+}
@@ -2,50 +2,121 @@ package strategies
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+
+	"beryju.org/korb/v2/pkg/archive"
+	"beryju.org/korb/v2/pkg/config"
+	"beryju.org/korb/v2/pkg/mover"
 )
 
+// DefaultTimeout is used for pods to start and PVCs to bind, unless overwritten by the user.
+const DefaultTimeout = 60 * time.Second
+
 type BaseStrategy struct {
 	kConfig *rest.Config
 	kClient *kubernetes.Clientset
 
+	// Only set when the destination is in a different cluster
+	destKConfig *rest.Config
+	destKClient *kubernetes.Clientset
+
 	log              *log.Entry
 	tolerateAllNodes bool
+	retainSourcePV   bool
+	offlineResize    bool
+	archivePath      string
+	encryption       *archive.Key
+	splitSize        int64
+	importMode       string
+	rsyncOptions     mover.RsyncOptions
+	filter           mover.Filter
+	ownership        mover.Ownership
+	sourceSubPath    string
+	destSubPath      string
+	mappings         []mover.Mapping
+	migrationID      string
 	timeout          time.Duration
 	copyTimeout      *time.Duration
+	onStage          func(stage int, description string)
 	ctx              context.Context
 }
 
 type BaseStrategyOpts struct {
 	Config           *rest.Config
 	Client           *kubernetes.Clientset
+	DestConfig       *rest.Config
+	DestClient       *kubernetes.Clientset
 	TolerateAllNodes bool
-	Timeout          *time.Duration
-	CopyTimeout      *time.Duration
-	Ctx              context.Context
+	RetainSourcePV   bool
+	OfflineResize    bool
+	// ArchivePath is the archive the export and import strategies write and read
+	ArchivePath string
+	// Encryption is the key archives are encrypted with, nil if they aren't encrypted
+	Encryption *archive.Key
+	// SplitSize splits exported archives into chunks of this many bytes, 0 disables splitting
+	SplitSize int64
+	// ImportMode decides how the import strategy handles existing data, one of ImportModes
+	ImportMode string
+	// RsyncOptions are passed to rsync by sync movers
+	RsyncOptions mover.RsyncOptions
+	// Filter selects which paths are copied, exported and imported
+	Filter mover.Filter
+	// Ownership changes the owner and permissions of copied and imported files
+	Ownership mover.Ownership
+	// SourceSubPath and DestSubPath limit the copy to a directory of the source and destination
+	SourceSubPath string
+	DestSubPath   string
+	MigrationID   string
+	Timeout       *time.Duration
+	CopyTimeout   *time.Duration
+	// Mappings are the directories the remap strategy copies between PVCs
+	Mappings []mover.Mapping
+	// OnStage is called whenever a strategy starts a new stage of the migration
+	OnStage func(stage int, description string)
+	Ctx     context.Context
 }
 
 func NewBaseStrategy(opts *BaseStrategyOpts) BaseStrategy {
 	var t time.Duration
 	if opts.Timeout == nil {
-		t = 60 * time.Second
+		t = DefaultTimeout
 	} else {
 		t = *opts.Timeout
 	}
 	return BaseStrategy{
 		kConfig:          opts.Config,
 		kClient:          opts.Client,
+		destKConfig:      opts.DestConfig,
+		destKClient:      opts.DestClient,
 		tolerateAllNodes: opts.TolerateAllNodes,
+		retainSourcePV:   opts.RetainSourcePV,
+		offlineResize:    opts.OfflineResize,
+		archivePath:      opts.ArchivePath,
+		encryption:       opts.Encryption,
+		splitSize:        opts.SplitSize,
+		importMode:       opts.ImportMode,
+		rsyncOptions:     opts.RsyncOptions,
+		filter:           opts.Filter,
+		ownership:        opts.Ownership,
+		sourceSubPath:    opts.SourceSubPath,
+		destSubPath:      opts.DestSubPath,
+		mappings:         opts.Mappings,
+		migrationID:      opts.MigrationID,
 		timeout:          t,
 		copyTimeout:      opts.CopyTimeout,
+		onStage:          opts.OnStage,
 		ctx:              opts.Ctx,
-		log:              log.WithField("component", "strategy"),
+		log:              log.WithField("component", "strategy").WithField("migration-id", opts.MigrationID),
 	}
 }
 
@@ -56,16 +127,215 @@ type Strategy interface {
 	Do(sourcePVC *v1.PersistentVolumeClaim, destTemplate *v1.PersistentVolumeClaim, WaitForTempDestPVCBind bool) error
 }
 
+// CapacityCheckSkipper can be implemented by strategies which don't copy data into
+// a new PVC, so korb doesn't check whether the source data fits into the destination.
+type CapacityCheckSkipper interface {
+	SkipCapacityCheck() bool
+}
+
+// LiveDataSafe can be implemented by strategies which can migrate PVCs while they are
+// used by pods. All other strategies require all pods using the source PVC to be stopped.
+type LiveDataSafe interface {
+	SafeForLiveData() bool
+}
+
+// CrossClusterCapable is implemented by strategies which can create the destination PVC in
+// a different cluster. When a destination cluster is configured, only these strategies are used.
+type CrossClusterCapable interface {
+	SupportsCrossCluster() bool
+}
+
+// Resumable is implemented by strategies which can finish a migration that was interrupted
+// after the source PVC was deleted, for example because korb was restarted. The objects of the
+// interrupted migration are found by the migration ID of the BaseStrategy.
+type Resumable interface {
+	Resume(destNamespace string, destName string) error
+}
+
+// SourceCreator is implemented by strategies which create the source PVC if it doesn't exist yet,
+// such as the import strategy. Only these strategies can be selected for a missing source PVC,
+// which is then passed to Do without a UID.
+type SourceCreator interface {
+	CreatesMissingSource() bool
+}
+
+// PathLister is implemented by strategies which don't read from the source PVC, such as the import
+// strategy. It lists the top-level paths which would be copied, for the dry-run of filters.
+type PathLister interface {
+	ListPaths(sourcePVC *v1.PersistentVolumeClaim) ([]string, error)
+}
+
+// MappingCapable is implemented by strategies which copy between the PVCs given by mappings,
+// such as the remap strategy. When mappings are given, only these strategies are used.
+type MappingCapable interface {
+	SupportsMappings() bool
+}
+
 type MigrationContext struct {
 	PVCControllers []interface{}
 	SourcePVC      v1.PersistentVolumeClaim
+	// PVCPods are the running pods which use the source PVC
+	PVCPods []v1.Pod
+	// DestTemplate is the PVC which would be created as destination
+	DestTemplate *v1.PersistentVolumeClaim
+	// DestStorageClass is the storage class of the destination PVC, nil if it doesn't use one
+	DestStorageClass *storagev1.StorageClass
+}
+
+// StrategyConstructor creates a new instance of a strategy, using the shared BaseStrategy.
+type StrategyConstructor func(b BaseStrategy) Strategy
+
+var registry = []StrategyConstructor{
+	func(b BaseStrategy) Strategy { return NewCopyTwiceNameStrategy(b) },
+	func(b BaseStrategy) Strategy { return NewExportStrategy(b) },
+	func(b BaseStrategy) Strategy { return NewImportStrategy(b) },
+	func(b BaseStrategy) Strategy { return NewExpandStrategy(b) },
+	func(b BaseStrategy) Strategy { return NewCopyCrossClusterStrategy(b) },
+	func(b BaseStrategy) Strategy { return NewRemapStrategy(b) },
+}
+
+// Register adds an out-of-tree strategy. Programs embedding korb should call this before
+// running any command, after which the strategy is listed and can be selected like the built-in ones.
+// Register panics if a strategy with the same identifier is already registered.
+func Register(constructor StrategyConstructor) {
+	identifier := constructor(NewBaseStrategy(&BaseStrategyOpts{})).Identifier()
+	for _, existing := range StrategyInstances(NewBaseStrategy(&BaseStrategyOpts{})) {
+		if existing.Identifier() == identifier {
+			panic(fmt.Sprintf("strategy '%s' is already registered", identifier))
+		}
+	}
+	registry = append(registry, constructor)
 }
 
 func StrategyInstances(b BaseStrategy) []Strategy {
-	s := []Strategy{
-		NewCopyTwiceNameStrategy(b),
-		NewExportStrategy(b),
-		NewImportStrategy(b),
+	s := make([]Strategy, 0, len(registry))
+	for _, constructor := range registry {
+		s = append(s, constructor(b))
 	}
 	return s
 }
+
+// stage logs the start of a new stage, and reports it to the OnStage callback.
+func (b *BaseStrategy) stage(stage int, description string) {
+	b.log.WithField("stage", stage).Debug(description)
+	if b.onStage != nil {
+		b.onStage(stage, description)
+	}
+}
+
+// removeMigrationLabel removes the migration ID from a PVC once it holds the complete data, so korb cleanup
+// and resumed migrations don't mistake it for a PVC which is still being filled by the migration.
+func (b *BaseStrategy) removeMigrationLabel(client *kubernetes.Clientset, pvc *v1.PersistentVolumeClaim) error {
+	patch := fmt.Sprintf(`{"metadata":{"labels":{"%s":null}}}`, config.LabelMigrationID)
+	_, err := client.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(b.ctx, pvc.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// Config returns the client config used to connect to the cluster.
+func (b *BaseStrategy) Config() *rest.Config {
+	return b.kConfig
+}
+
+// Client returns the Kubernetes client.
+func (b *BaseStrategy) Client() *kubernetes.Clientset {
+	return b.kClient
+}
+
+// DestConfig returns the client config for the destination cluster, or nil if
+// the destination is in the same cluster.
+func (b *BaseStrategy) DestConfig() *rest.Config {
+	return b.destKConfig
+}
+
+// DestClient returns the client for the destination cluster, or nil if
+// the destination is in the same cluster.
+func (b *BaseStrategy) DestClient() *kubernetes.Clientset {
+	return b.destKClient
+}
+
+// Context returns the context of the current migration.
+func (b *BaseStrategy) Context() context.Context {
+	return b.ctx
+}
+
+// Log returns the logger of the strategy.
+func (b *BaseStrategy) Log() *log.Entry {
+	return b.log
+}
+
+// Timeout returns the timeout for pods to start and PVCs to bind.
+func (b *BaseStrategy) Timeout() time.Duration {
+	return b.timeout
+}
+
+// CopyTimeout returns the user-defined copy timeout, or nil if it should be derived from the PVC size.
+func (b *BaseStrategy) CopyTimeout() *time.Duration {
+	return b.copyTimeout
+}
+
+// TolerateAllNodes returns whether mover jobs should tolerate all node taints.
+func (b *BaseStrategy) TolerateAllNodes() bool {
+	return b.tolerateAllNodes
+}
+
+// ArchivePath returns the path of the archive for the PVC name, which the export strategy writes
+// and the import strategy reads. Unless set by the user, this is <name>.tar in the current directory.
+func (b *BaseStrategy) ArchivePath(name string) string {
+	if b.archivePath != "" {
+		return b.archivePath
+	}
+	return fmt.Sprintf("%s.tar", name)
+}
+
+// Encryption returns the key archives are encrypted with, or nil if they aren't encrypted.
+func (b *BaseStrategy) Encryption() *archive.Key {
+	return b.encryption
+}
+
+// SplitSize returns the size of the chunks exported archives are split into, or 0 if they aren't split.
+func (b *BaseStrategy) SplitSize() int64 {
+	return b.splitSize
+}
+
+// ImportMode returns how the import strategy handles data which is already on the volume.
+func (b *BaseStrategy) ImportMode() string {
+	if b.importMode == "" {
+		return ImportModeMerge
+	}
+	return b.importMode
+}
+
+// RsyncOptions returns the options sync movers pass to rsync.
+func (b *BaseStrategy) RsyncOptions() mover.RsyncOptions {
+	return b.rsyncOptions
+}
+
+// Filter returns the include and exclude patterns which select the paths that are copied.
+func (b *BaseStrategy) Filter() mover.Filter {
+	return b.filter
+}
+
+// Ownership returns the owner and permission changes applied to copied and imported files.
+func (b *BaseStrategy) Ownership() mover.Ownership {
+	return b.ownership
+}
+
+// SourceSubPath returns the directory of the source which is copied, or "" for the whole volume.
+func (b *BaseStrategy) SourceSubPath() string {
+	return b.sourceSubPath
+}
+
+// DestSubPath returns the directory of the destination the data is copied into, or "" for the root of the volume.
+func (b *BaseStrategy) DestSubPath() string {
+	return b.destSubPath
+}
+
+// Mappings returns the directories which are copied between PVCs by the remap strategy.
+func (b *BaseStrategy) Mappings() []mover.Mapping {
+	return b.mappings
+}
+
+// MigrationID returns the ID of the current migration.
+func (b *BaseStrategy) MigrationID() string {
+	return b.migrationID
+}